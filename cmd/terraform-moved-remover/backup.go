@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultBackupSuffix is appended to backups when -backup is given without
+// a suffix
+const defaultBackupSuffix = ".bak"
+
+// backupFlag is the -backup option. It can be given on its own to use the
+// default suffix or as -backup=SUFFIX.
+type backupFlag struct {
+	Suffix string
+}
+
+func (f *backupFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.Suffix
+}
+
+func (f *backupFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.Suffix = defaultBackupSuffix
+	case "false":
+		f.Suffix = ""
+	case "":
+		return fmt.Errorf("backup suffix must not be empty")
+	default:
+		f.Suffix = value
+	}
+	return nil
+}
+
+// IsBoolFlag lets -backup be used without a value
+func (f *backupFlag) IsBoolFlag() bool {
+	return true
+}
+
+// backupConfig describes where the original content of modified files is
+// kept. Either Suffix or Dir is set.
+type backupConfig struct {
+	// Root is the scanned directory; paths under Dir mirror paths under Root
+	Root   string
+	Suffix string
+	Dir    string
+}
+
+// path returns the backup path for a file under Root
+func (b *backupConfig) path(filePath string) (string, error) {
+	if b.Suffix != "" {
+		return filePath + b.Suffix, nil
+	}
+	rel, err := filepath.Rel(b.Root, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file %s is outside of %s", filePath, b.Root)
+	}
+	return filepath.Join(b.Dir, rel), nil
+}
+
+// save writes the original content of a file to its backup path, replacing
+// a backup from an earlier run
+func (b *backupConfig) save(filePath string, content []byte) error {
+	backupPath, err := b.path(filePath)
+	if err != nil {
+		return fmt.Errorf("error backing up file %s: %w", filePath, err)
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
+		return fmt.Errorf("error backing up file %s: %w", filePath, err)
+	}
+	if err := os.WriteFile(backupPath, content, mode); err != nil {
+		return fmt.Errorf("error backing up file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// backupDirs returns the directories discovery must skip so that backups
+// are not processed themselves
+func backupDirs(b *backupConfig) []string {
+	if b == nil || b.Dir == "" {
+		return nil
+	}
+	return []string{b.Dir}
+}
+
+// restoreBackups copies every backup under the configuration back over the
+// file it was taken from and removes the backup. It returns the restored
+// files.
+func restoreBackups(b *backupConfig) ([]string, error) {
+	walkDir := b.Root
+	if b.Dir != "" {
+		walkDir = b.Dir
+	}
+
+	var backups []string
+	err := filepath.Walk(walkDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", path, err)
+		}
+		if !info.IsDir() && (b.Dir != "" || strings.HasSuffix(path, b.Suffix)) {
+			backups = append(backups, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var restored []string
+	for _, backupPath := range backups {
+		target := strings.TrimSuffix(backupPath, b.Suffix)
+		if b.Dir != "" {
+			rel, err := filepath.Rel(b.Dir, backupPath)
+			if err != nil {
+				return restored, err
+			}
+			target = filepath.Join(b.Root, rel)
+		}
+		if !strings.HasSuffix(target, ".tf") && !isTerragruntFile(target) {
+			continue
+		}
+
+		content, err := os.ReadFile(backupPath)
+		if err != nil {
+			return restored, fmt.Errorf("error reading backup %s: %w", backupPath, err)
+		}
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(backupPath); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return restored, fmt.Errorf("error restoring file %s: %w", target, err)
+		}
+		if err := os.WriteFile(target, content, mode); err != nil {
+			return restored, fmt.Errorf("error restoring file %s: %w", target, err)
+		}
+		if err := os.Remove(backupPath); err != nil {
+			return restored, fmt.Errorf("error removing backup %s: %w", backupPath, err)
+		}
+		restored = append(restored, target)
+	}
+	return restored, nil
+}
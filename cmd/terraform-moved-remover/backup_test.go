@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const backupTestContent = `resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+
+// TestBackupSuffix tests keeping originals next to modified files and
+// restoring them
+func TestBackupSuffix(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"main.tf":          backupTestContent,
+		"modules/x/vpc.tf": "resource \"aws_vpc\" \"main\" {}\n",
+	})
+
+	cfg := &backupConfig{Root: rootDir, Suffix: ".orig"}
+	stats := Stats{Backup: cfg}
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	processFiles(files, &stats)
+	if len(stats.Failures) != 0 {
+		t.Fatalf("Expected no failures, but got %v", stats.Failures)
+	}
+
+	backup, err := os.ReadFile(filepath.Join(rootDir, "main.tf.orig"))
+	if err != nil {
+		t.Fatalf("Expected a backup of main.tf: %v", err)
+	}
+	if string(backup) != backupTestContent {
+		t.Errorf("Expected the backup to hold the original content, but got:\n%s", backup)
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "modules", "x", "vpc.tf.orig")); !os.IsNotExist(err) {
+		t.Errorf("Expected no backup for an unmodified file")
+	}
+
+	restored, err := restoreBackups(cfg)
+	if err != nil {
+		t.Fatalf("restoreBackups failed: %v", err)
+	}
+	if len(restored) != 1 {
+		t.Errorf("Expected 1 restored file, but got %v", restored)
+	}
+	content, _ := os.ReadFile(filepath.Join(rootDir, "main.tf"))
+	if string(content) != backupTestContent {
+		t.Errorf("Expected main.tf to be restored, but got:\n%s", content)
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "main.tf.orig")); !os.IsNotExist(err) {
+		t.Errorf("Expected the backup to be removed after restoring")
+	}
+}
+
+// TestBackupDir tests mirroring originals under a backup directory inside
+// the scanned tree
+func TestBackupDir(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"live/app/main.tf": backupTestContent,
+	})
+	backupDir := filepath.Join(rootDir, ".backup")
+
+	cfg := &backupConfig{Root: rootDir, Dir: backupDir}
+	for run := 0; run < 2; run++ {
+		files, _, err := discoverFiles(rootDir, discoveryOptions{ExcludeDirs: backupDirs(cfg)})
+		if err != nil {
+			t.Fatalf("discoverFiles failed: %v", err)
+		}
+		if len(files) != 1 {
+			t.Fatalf("Expected the backup directory to be skipped, but got %v", files)
+		}
+		stats := Stats{Backup: cfg}
+		processFiles(files, &stats)
+		if len(stats.Failures) != 0 {
+			t.Fatalf("Expected no failures, but got %v", stats.Failures)
+		}
+	}
+
+	// The second run did not modify anything, so the backup is the original
+	backup, err := os.ReadFile(filepath.Join(backupDir, "live", "app", "main.tf"))
+	if err != nil {
+		t.Fatalf("Expected a mirrored backup: %v", err)
+	}
+	if string(backup) != backupTestContent {
+		t.Errorf("Expected the backup to hold the original content, but got:\n%s", backup)
+	}
+
+	if _, err := restoreBackups(cfg); err != nil {
+		t.Fatalf("restoreBackups failed: %v", err)
+	}
+	content, _ := os.ReadFile(filepath.Join(rootDir, "live", "app", "main.tf"))
+	if string(content) != backupTestContent {
+		t.Errorf("Expected main.tf to be restored, but got:\n%s", content)
+	}
+}
+
+// TestBackupFlag tests the -backup option with and without a suffix
+func TestBackupFlag(t *testing.T) {
+	testCases := []struct {
+		args     []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"-backup"}, ".bak"},
+		{[]string{"-backup=.orig"}, ".orig"},
+		{[]string{"-backup=false"}, ""},
+	}
+
+	for _, tc := range testCases {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		backup := &backupFlag{}
+		fs.Var(backup, "backup", "")
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatalf("Parse(%v) failed: %v", tc.args, err)
+		}
+		if backup.Suffix != tc.expected {
+			t.Errorf("For %v, expected suffix %q, but got %q", tc.args, tc.expected, backup.Suffix)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheFile is the name of the cache kept in the scanned directory
+const cacheFile = ".moved-remover-cache"
+
+// runCache remembers the files that had no moved blocks and were already
+// formatted, so that later runs can skip them without parsing. It is only
+// valid for the tool version and options it was written with.
+type runCache struct {
+	Version     int                   `json:"version"`
+	ToolVersion string                `json:"tool_version"`
+	Options     string                `json:"options"`
+	Time        time.Time             `json:"time"`
+	Files       map[string]cacheEntry `json:"files"`
+
+	// root is the scanned directory that paths are relative to
+	root string
+	// seen holds the entries confirmed by this run, and checked the files
+	// this run looked at, cached or not
+	seen    map[string]cacheEntry
+	checked map[string]bool
+	// Invalidated is set when a cache was found but written with another
+	// tool version or other options
+	Invalidated bool `json:"-"`
+}
+
+// cacheEntry identifies the content a file had when it was cached
+type cacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Hash    string `json:"hash"`
+}
+
+// cachePath returns the path of the cache for a scanned directory
+func cachePath(rootDir string) string {
+	return filepath.Join(rootDir, cacheFile)
+}
+
+// cacheOptions describes the options that affect the result of processing
+// a file, including the hash of the configuration file, if any. A cache
+// written with other options is discarded.
+func cacheOptions(stats *Stats, discovery discoveryOptions, configHash string) string {
+	return strings.Join([]string{
+		fmt.Sprintf("normalize-whitespace=%t", stats.NormalizeWhitespace),
+		fmt.Sprintf("terragrunt=%t", discovery.Terragrunt),
+		"config=" + configHash,
+	}, ";")
+}
+
+// loadCache reads the cache of a scanned directory. A missing or unreadable
+// cache, or one written by another version or with other options, yields an
+// empty cache.
+func loadCache(rootDir, options string) *runCache {
+	c := &runCache{
+		Version:     1,
+		ToolVersion: Version,
+		Options:     options,
+		Files:       make(map[string]cacheEntry),
+		root:        rootDir,
+		seen:        make(map[string]cacheEntry),
+		checked:     make(map[string]bool),
+	}
+
+	content, err := os.ReadFile(cachePath(rootDir))
+	if err != nil {
+		return c
+	}
+	var stored runCache
+	if err := json.Unmarshal(content, &stored); err != nil || stored.Version != c.Version {
+		c.Invalidated = true
+		return c
+	}
+	if stored.ToolVersion != c.ToolVersion || stored.Options != c.Options {
+		c.Invalidated = true
+		return c
+	}
+	c.Time = stored.Time
+	if stored.Files != nil {
+		c.Files = stored.Files
+	}
+	return c
+}
+
+// key returns the cache key of a file, its slash separated path relative to
+// the scanned directory
+func (c *runCache) key(filePath string) string {
+	rel, err := filepath.Rel(c.root, filePath)
+	if err != nil {
+		return filepath.ToSlash(filePath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// fresh reports whether a file is unchanged since it was cached, judging by
+// its size and modification time alone. Files modified too close to when the
+// cache was written could have changed again within the resolution of the
+// modification time, so they are not trusted.
+func (c *runCache) fresh(filePath string, info os.FileInfo) bool {
+	c.checked[c.key(filePath)] = true
+	entry, ok := c.Files[c.key(filePath)]
+	if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		return false
+	}
+	if !info.ModTime().Before(c.Time.Add(-time.Second)) {
+		return false
+	}
+	c.seen[c.key(filePath)] = entry
+	return true
+}
+
+// matches reports whether a file's content is what was cached, for files
+// whose modification time changed without their content changing
+func (c *runCache) matches(filePath string, info os.FileInfo, content []byte) bool {
+	entry, ok := c.Files[c.key(filePath)]
+	if !ok || entry.Hash != contentHash(content) {
+		return false
+	}
+	c.store(filePath, info, content)
+	return true
+}
+
+// store records a file that has no moved blocks and is already formatted
+func (c *runCache) store(filePath string, info os.FileInfo, content []byte) {
+	c.seen[c.key(filePath)] = cacheEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Hash:    contentHash(content),
+	}
+}
+
+// write saves the files confirmed by this run. Files this run did not look
+// at, such as files outside -since, keep their entries while they exist,
+// unless the entry was not trusted yet: the cache gets a newer time, which
+// would make it trusted.
+func (c *runCache) write() error {
+	for key, entry := range c.Files {
+		if c.checked[key] || !time.Unix(0, entry.ModTime).Before(c.Time.Add(-time.Second)) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(c.root, filepath.FromSlash(key))); err == nil {
+			c.seen[key] = entry
+		}
+	}
+	c.Files = c.seen
+	c.Time = time.Now()
+	content, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error writing cache: %w", err)
+	}
+	if err := os.WriteFile(cachePath(c.root), append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing cache: %w", err)
+	}
+	return nil
+}
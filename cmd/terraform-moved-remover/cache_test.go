@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runWithCache processes the files of rootDir with a cache for the given
+// options and writes the cache afterwards
+func runWithCache(t *testing.T, rootDir, options string) Stats {
+	t.Helper()
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	stats := Stats{Cache: loadCache(rootDir, options)}
+	processFiles(files, &stats)
+	if len(stats.Failures) > 0 {
+		t.Fatalf("Unexpected failures: %+v", stats.Failures)
+	}
+	if err := stats.Cache.write(); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	return stats
+}
+
+// setModTime sets the modification time of a file
+func setModTime(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("Failed to set modification time of %s: %v", path, err)
+	}
+}
+
+// TestCache tests that files without moved blocks are skipped on later runs
+// until they change or the options change
+func TestCache(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"clean.tf":       "resource \"aws_instance\" \"web\" {}\n",
+		"moved.tf":       "moved {\n  from = aws_instance.old\n  to   = aws_instance.web\n}\n",
+		"unformatted.tf": "resource \"aws_vpc\" \"main\" {\n    cidr_block = \"10.0.0.0/16\"\n}\n",
+	})
+	clean := filepath.Join(rootDir, "clean.tf")
+	hourAgo := time.Now().Add(-time.Hour)
+	for _, name := range []string{"clean.tf", "moved.tf", "unformatted.tf"} {
+		setModTime(t, filepath.Join(rootDir, name), hourAgo)
+	}
+
+	stats := runWithCache(t, rootDir, "a")
+	if stats.CacheHits != 0 || stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected no cache hits and 1 block removed on the first run, but got %d and %d", stats.CacheHits, stats.MovedBlocksRemoved)
+	}
+	if len(stats.Cache.Files) != 1 {
+		t.Errorf("Expected only clean.tf in the cache, but got %v", stats.Cache.Files)
+	}
+
+	// clean.tf is skipped. The other files were written by the first run,
+	// too recently to be trusted, so they are read and cached now.
+	stats = runWithCache(t, rootDir, "a")
+	if stats.CacheHits != 1 || stats.FilesProcessed != 3 {
+		t.Errorf("Expected 1 cache hit out of 3 files, but got %d out of %d", stats.CacheHits, stats.FilesProcessed)
+	}
+
+	// A new modification time with the same content is still a hit
+	setModTime(t, clean, hourAgo.Add(time.Minute))
+	stats = runWithCache(t, rootDir, "a")
+	if stats.CacheHits != 3 {
+		t.Errorf("Expected 3 cache hits, but got %d", stats.CacheHits)
+	}
+
+	// Changed content is not
+	if err := os.WriteFile(clean, []byte("moved {\n  from = aws_instance.a\n  to   = aws_instance.b\n}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	setModTime(t, clean, hourAgo)
+	stats = runWithCache(t, rootDir, "a")
+	if stats.CacheHits != 2 || stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected 2 cache hits and 1 block removed, but got %d and %d", stats.CacheHits, stats.MovedBlocksRemoved)
+	}
+
+	// Other options invalidate the cache
+	stats = runWithCache(t, rootDir, "b")
+	if !stats.Cache.Invalidated || stats.CacheHits != 0 {
+		t.Errorf("Expected the cache to be invalidated, but got %d hits", stats.CacheHits)
+	}
+}
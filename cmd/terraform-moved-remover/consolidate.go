@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// consolidateOptions controls which blocks the consolidate subcommand moves
+// and what happens to the files they leave behind
+type consolidateOptions struct {
+	Out         string
+	Types       map[string]bool
+	DeleteEmpty bool
+}
+
+// consolidatedBlock is a block moved into the target file of its module
+type consolidatedBlock struct {
+	Path string
+	Line int
+	Type string
+	Text []byte
+}
+
+// consolidation is the planned result for one module directory. Nothing is
+// written until apply is called.
+type consolidation struct {
+	Dir     string
+	Target  string
+	Blocks  []consolidatedBlock
+	Content []byte            // new contents of the target file
+	Sources map[string][]byte // new contents of the files blocks were taken from
+	Deleted []string          // source files left empty that are deleted
+}
+
+// groupByModule groups files by their directory, returning the directories
+// in sorted order and the files of each in sorted order
+func groupByModule(files []string) ([]string, map[string][]string) {
+	byDir := make(map[string][]string)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		byDir[dir] = append(byDir[dir], file)
+	}
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+		sort.Strings(byDir[dir])
+	}
+	sort.Strings(dirs)
+	return dirs, byDir
+}
+
+// planConsolidation collects the blocks of the selected types from the files
+// of a module directory, in file and then line order, and computes the new
+// contents of the target file and of the files they are taken from. Each
+// block is taken together with its leading comments. It returns nil when
+// there is nothing to move.
+func planConsolidation(dir string, files []string, opts consolidateOptions) (*consolidation, error) {
+	c := &consolidation{
+		Dir:     dir,
+		Target:  filepath.Join(dir, opts.Out),
+		Sources: make(map[string][]byte),
+	}
+
+	for _, filePath := range files {
+		if filePath == c.Target {
+			continue
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s: %w", filePath, err)
+		}
+		format := remover.DetectTextFormat(content)
+		normalized := format.Normalize(content)
+
+		file, diags := hclwrite.ParseConfig(normalized, filePath, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			return nil, &remover.DiagnosticsError{Path: filePath, Diagnostics: diags}
+		}
+		// hclwrite does not track source ranges, see remover.Parse
+		syntaxFile, diags := hclsyntax.ParseConfig(normalized, filePath, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			return nil, &remover.DiagnosticsError{Path: filePath, Diagnostics: diags}
+		}
+		syntaxBlocks := syntaxFile.Body.(*hclsyntax.Body).Blocks
+
+		var blocks []*hclwrite.Block
+		for i, block := range file.Body().Blocks() {
+			if !opts.Types[block.Type()] {
+				continue
+			}
+			moved := consolidatedBlock{Path: filePath, Type: block.Type(), Text: block.BuildTokens(nil).Bytes()}
+			if i < len(syntaxBlocks) {
+				moved.Line = syntaxBlocks[i].Range().Start.Line
+			}
+			c.Blocks = append(c.Blocks, moved)
+			blocks = append(blocks, block)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		remaining := hclwrite.Format(remover.RemoveBlocks(file, blocks))
+		if opts.DeleteEmpty && isEmptyConfig(remaining) {
+			c.Deleted = append(c.Deleted, filePath)
+			continue
+		}
+		c.Sources[filePath] = format.Apply(remaining)
+	}
+
+	if len(c.Blocks) == 0 {
+		return nil, nil
+	}
+
+	// Append the blocks to the target file, after any blocks already in it
+	existing, err := os.ReadFile(c.Target)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading file %s: %w", c.Target, err)
+	}
+	format := remover.DetectTextFormat(existing)
+	target := bytes.TrimRight(format.Normalize(existing), "\n")
+	if _, diags := hclwrite.ParseConfig(target, c.Target, hcl.Pos{Line: 1, Column: 1}); diags.HasErrors() {
+		return nil, &remover.DiagnosticsError{Path: c.Target, Diagnostics: diags}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(target)
+	for _, block := range c.Blocks {
+		if buf.Len() > 0 {
+			buf.WriteString("\n\n")
+		}
+		buf.Write(bytes.TrimRight(block.Text, "\n"))
+	}
+	buf.WriteString("\n")
+	c.Content = format.Apply(hclwrite.Format(buf.Bytes()))
+
+	return c, nil
+}
+
+// apply writes the planned changes. The target file is written first so
+// that no block is lost if writing a source file fails.
+func (c *consolidation) apply() error {
+	if err := os.WriteFile(c.Target, c.Content, 0644); err != nil {
+		return fmt.Errorf("error writing file %s: %w", c.Target, err)
+	}
+
+	paths := make([]string, 0, len(c.Sources))
+	for path := range c.Sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := os.WriteFile(path, c.Sources[path], 0644); err != nil {
+			return fmt.Errorf("error writing file %s: %w", path, err)
+		}
+	}
+
+	for _, path := range c.Deleted {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("error deleting file %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// runConsolidate implements the consolidate subcommand
+func runConsolidate(args []string) int {
+	fs := flag.NewFlagSet("consolidate", flag.ExitOnError)
+	outFlag := fs.String("out", defaultMovedFile, "File in each module directory that blocks are moved to")
+	removedFlag := fs.Bool("include-removed", false, "Also move removed blocks")
+	importFlag := fs.Bool("include-import", false, "Also move import blocks")
+	deleteEmptyFlag := fs.Bool("delete-empty", false, "Delete files left with nothing but comments after their blocks are moved")
+	dryRunFlag := fs.Bool("dry-run", false, "Show what would be moved without writing any files")
+	fs.Usage = func() {
+		fmt.Println("Usage: terraform-moved-remover consolidate [options] [directory]")
+		fmt.Println("       Moves the moved blocks of each module directory into one file,")
+		fmt.Println("       ordered by source file and then line.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 1
+	}
+	rootDir := "."
+	if fs.NArg() == 1 {
+		rootDir = fs.Arg(0)
+	}
+	if *outFlag == "" || filepath.Base(*outFlag) != *outFlag || filepath.Ext(*outFlag) != ".tf" {
+		fmt.Printf("Error: -out must be a .tf file name, got %q\n", *outFlag)
+		return 1
+	}
+
+	opts := consolidateOptions{
+		Out:         *outFlag,
+		Types:       map[string]bool{"moved": true, "removed": *removedFlag, "import": *importFlag},
+		DeleteEmpty: *deleteEmptyFlag,
+	}
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	if *dryRunFlag {
+		fmt.Println("Dry run: no files will be modified")
+	}
+
+	status := 0
+	moved, modules := 0, 0
+	dirs, byDir := groupByModule(files)
+	for _, dir := range dirs {
+		c, err := planConsolidation(dir, byDir[dir], opts)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			status = 1
+			continue
+		}
+		if c == nil {
+			continue
+		}
+
+		fmt.Printf("%s: %d blocks\n", c.Target, len(c.Blocks))
+		for _, block := range c.Blocks {
+			fmt.Printf("  %s:%d %s\n", block.Path, block.Line, block.Type)
+		}
+		for _, path := range c.Deleted {
+			fmt.Printf("  Deleted empty file %s\n", path)
+		}
+
+		if !*dryRunFlag {
+			if err := c.apply(); err != nil {
+				fmt.Printf("Error: %s\n", err)
+				status = 1
+				continue
+			}
+		}
+		moved += len(c.Blocks)
+		modules++
+	}
+
+	fmt.Printf("\nConsolidated %d blocks in %d modules\n", moved, modules)
+	return status
+}
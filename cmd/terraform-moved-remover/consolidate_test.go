@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestConsolidate tests moving blocks into the target file of a module
+func TestConsolidate(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"b.tf": `resource "aws_instance" "web" {}
+
+# Renamed the database
+moved {
+  from = aws_db_instance.old
+  to   = aws_db_instance.main
+}
+`,
+		"a.tf": `# Only refactoring directives
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+
+removed {
+  from = aws_s3_bucket.logs
+}
+`,
+		"moved.tf": `moved {
+  from = module.old
+  to   = module.new
+}
+`,
+	})
+
+	files, err := findTerraformFiles(root)
+	if err != nil {
+		t.Fatalf("Failed to find files: %v", err)
+	}
+	opts := consolidateOptions{
+		Out:         defaultMovedFile,
+		Types:       map[string]bool{"moved": true, "removed": true},
+		DeleteEmpty: true,
+	}
+	c, err := planConsolidation(root, files, opts)
+	if err != nil {
+		t.Fatalf("planConsolidation failed: %v", err)
+	}
+	if c == nil || len(c.Blocks) != 3 {
+		t.Fatalf("Expected 3 blocks, but got %+v", c)
+	}
+	if c.Blocks[0].Path != filepath.Join(root, "a.tf") || c.Blocks[0].Line != 2 || c.Blocks[2].Path != filepath.Join(root, "b.tf") {
+		t.Errorf("Expected blocks ordered by file and line, but got %+v", c.Blocks)
+	}
+	if err := c.apply(); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	expected := `moved {
+  from = module.old
+  to   = module.new
+}
+
+# Only refactoring directives
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+
+removed {
+  from = aws_s3_bucket.logs
+}
+
+# Renamed the database
+moved {
+  from = aws_db_instance.old
+  to   = aws_db_instance.main
+}
+`
+	content, err := os.ReadFile(filepath.Join(root, "moved.tf"))
+	if err != nil {
+		t.Fatalf("Failed to read moved.tf: %v", err)
+	}
+	if string(content) != expected {
+		t.Errorf("Expected moved.tf:\n%s\nActual:\n%s", expected, content)
+	}
+
+	content, err = os.ReadFile(filepath.Join(root, "b.tf"))
+	if err != nil {
+		t.Fatalf("Failed to read b.tf: %v", err)
+	}
+	if string(content) != "resource \"aws_instance\" \"web\" {}\n" {
+		t.Errorf("Unexpected b.tf:\n%s", content)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "a.tf")); !os.IsNotExist(err) {
+		t.Errorf("Expected a.tf to be deleted, but got %v", err)
+	}
+}
+
+// TestConsolidateKeepEmpty tests that emptied files are kept by default and
+// that only moved blocks are moved unless other types are asked for
+func TestConsolidateKeepEmpty(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"main.tf": `moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+
+import {
+  to = aws_instance.web
+  id = "i-12345"
+}
+`,
+		"refactor.tf": `moved {
+  from = aws_instance.a
+  to   = aws_instance.b
+}
+`,
+	})
+
+	files, err := findTerraformFiles(root)
+	if err != nil {
+		t.Fatalf("Failed to find files: %v", err)
+	}
+	c, err := planConsolidation(root, files, consolidateOptions{Out: "refactor.tf", Types: map[string]bool{"moved": true}})
+	if err != nil {
+		t.Fatalf("planConsolidation failed: %v", err)
+	}
+	if c == nil || len(c.Blocks) != 1 || len(c.Deleted) != 0 {
+		t.Fatalf("Expected 1 block and no deleted files, but got %+v", c)
+	}
+	if err := c.apply(); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(root, "main.tf"))
+	if err != nil {
+		t.Fatalf("Failed to read main.tf: %v", err)
+	}
+	expected := "import {\n  to = aws_instance.web\n  id = \"i-12345\"\n}\n"
+	if string(content) != expected {
+		t.Errorf("Expected main.tf:\n%s\nActual:\n%s", expected, content)
+	}
+
+	// Running again finds nothing left to move
+	files, _ = findTerraformFiles(root)
+	c, err = planConsolidation(root, files, consolidateOptions{Out: "refactor.tf", Types: map[string]bool{"moved": true}})
+	if err != nil || c != nil {
+		t.Errorf("Expected nothing to consolidate, but got %+v, %v", c, err)
+	}
+}
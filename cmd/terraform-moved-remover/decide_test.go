@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/mkusaka/terraform-moved-remover/decision"
+)
+
+const decideInput = `resource "aws_instance" "web" {}
+
+moved {
+  from = module.prod_db.aws_db_instance.main
+  to   = module.db.aws_db_instance.main
+}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+
+// TestDeciderFailure tests that blocks decided before a decider fails are
+// not recorded, since the file is left unchanged
+func TestDeciderFailure(t *testing.T) {
+	calls := 0
+	failSecond := decision.DeciderFunc(func(decision.Block) (decision.Result, error) {
+		calls++
+		if calls == 2 {
+			return decision.Result{}, errors.New("policy service unavailable")
+		}
+		return decision.Result{Action: decision.Remove, Reason: "allowed"}, nil
+	})
+	stats := Stats{Deciders: []decision.Decider{failSecond}}
+	if _, _, err := removeMovedBlocks("main.tf", []byte(decideInput), &stats); err == nil {
+		t.Fatalf("Expected the decider's error on the second block")
+	}
+	if len(stats.RemovedBlocks) != 0 || len(stats.KeptBlocks) != 0 {
+		t.Errorf("Expected no removed or kept blocks, but got %+v and %+v", stats.RemovedBlocks, stats.KeptBlocks)
+	}
+}
+
+// TestDecisionPlugin tests keeping moved blocks with an external plugin
+func TestDecisionPlugin(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	dir := t.TempDir()
+	plugin := filepath.Join(dir, "plugin.sh")
+	script := `#!/bin/sh
+while read -r line; do
+  case "$line" in
+    *prod_db*) echo '{"action": "keep", "reason": "prod is frozen"}' ;;
+    *) echo '{"action": "remove"}' ;;
+  esac
+done
+`
+	if err := os.WriteFile(plugin, []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write plugin: %v", err)
+	}
+	args, err := splitCommand("decision plugin", "sh "+plugin)
+	if err != nil {
+		t.Fatalf("splitCommand failed: %v", err)
+	}
+	p, err := decision.StartPlugin(args)
+	if err != nil {
+		t.Fatalf("StartPlugin failed: %v", err)
+	}
+
+	stats := Stats{Deciders: []decision.Decider{p}}
+	_, removed, err := removeMovedBlocks("main.tf", []byte(decideInput), &stats)
+	closeDeciders(&stats)
+	if err != nil {
+		t.Fatalf("removeMovedBlocks failed: %v", err)
+	}
+	if removed != 1 || len(stats.KeptBlocks) != 1 || stats.KeptBlocks[0].Reason != "prod is frozen" {
+		t.Errorf("Expected the plugin to keep the prod block, but got %d removed and %+v", removed, stats.KeptBlocks)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// diagnosticsWidth is the column at which diagnostic detail text is wrapped
+const diagnosticsWidth = 78
+
+// addDiagnostics records diagnostics for a file along with its source so the
+// final report can show the offending lines
+func (s *Stats) addDiagnostics(filePath string, content []byte, diags hcl.Diagnostics) {
+	if len(diags) == 0 {
+		return
+	}
+	if s.Sources == nil {
+		s.Sources = make(map[string]*hcl.File)
+	}
+	s.Sources[filePath] = &hcl.File{Bytes: content}
+	s.Diagnostics = append(s.Diagnostics, diags...)
+}
+
+// writeDiagnostics renders diagnostics using the HCL text writer and adds a
+// caret line under the subject of each diagnostic
+func writeDiagnostics(w io.Writer, files map[string]*hcl.File, diags hcl.Diagnostics, color bool) error {
+	for _, diag := range diags {
+		var buf bytes.Buffer
+		wr := hcl.NewDiagnosticTextWriter(&buf, files, diagnosticsWidth, color)
+		if err := wr.WriteDiagnostic(diag); err != nil {
+			return err
+		}
+
+		out := buf.String()
+		if diag.Subject != nil && files[diag.Subject.Filename] != nil {
+			out = insertCaret(out, diag, color)
+		}
+		if _, err := io.WriteString(w, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertCaret adds a line of carets below the snippet line where the
+// diagnostic subject starts
+func insertCaret(rendered string, diag *hcl.Diagnostic, color bool) string {
+	subject := diag.Subject
+	prefix := fmt.Sprintf("%4d: ", subject.Start.Line)
+
+	lines := strings.SplitAfter(rendered, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+
+		width := 1
+		if subject.End.Line == subject.Start.Line && subject.End.Column > subject.Start.Column {
+			width = subject.End.Column - subject.Start.Column
+		}
+
+		// Keep tabs from the source line so the caret lines up in terminals
+		src := []rune(stripANSI(strings.TrimSuffix(line[len(prefix):], "\n")))
+		var pad strings.Builder
+		for c := 0; c < subject.Start.Column-1 && c < len(src); c++ {
+			if src[c] == '\t' {
+				pad.WriteRune('\t')
+			} else {
+				pad.WriteRune(' ')
+			}
+		}
+
+		caret := strings.Repeat("^", width)
+		if color {
+			caret = severityColor(diag.Severity) + caret + "\x1b[0m"
+		}
+		caretLine := strings.Repeat(" ", len(prefix)) + pad.String() + caret + "\n"
+
+		lines = append(lines[:i+1], append([]string{caretLine}, lines[i+1:]...)...)
+		break
+	}
+	return strings.Join(lines, "")
+}
+
+// severityColor returns the escape sequence used by the HCL text writer for
+// the given severity
+func severityColor(severity hcl.DiagnosticSeverity) string {
+	if severity == hcl.DiagWarning {
+		return "\x1b[33m"
+	}
+	return "\x1b[31m"
+}
+
+// stripANSI removes VT100 escape sequences from s
+func stripANSI(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\x1b' {
+			for i < len(s) && s[i] != 'm' {
+				i++
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isTerminal reports whether f refers to a terminal. Colour is also disabled
+// when NO_COLOR is set.
+func isTerminal(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
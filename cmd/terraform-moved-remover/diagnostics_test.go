@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// TestParseDiagnostics tests that parse failures keep their diagnostics and
+// render with source snippets
+func TestParseDiagnostics(t *testing.T) {
+	tempDir := t.TempDir()
+
+	invalidFile := filepath.Join(tempDir, "invalid.tf")
+	content := `resource "aws_instance" "web" {
+  ami = "ami-123456"
+}
+
+moved {
+  from = aws_instance.old
+  to   = = aws_instance.web
+}
+`
+	if err := os.WriteFile(invalidFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write invalid file: %v", err)
+	}
+
+	stats := Stats{}
+	err := processFile(invalidFile, &stats)
+	if err == nil {
+		t.Fatalf("Expected error for invalid HCL, but got nil")
+	}
+
+	var diagErr *remover.DiagnosticsError
+	if !errors.As(err, &diagErr) {
+		t.Fatalf("Expected *remover.DiagnosticsError, but got %T", err)
+	}
+	if !strings.Contains(err.Error(), "error parsing "+invalidFile) {
+		t.Errorf("Unexpected error message: %s", err)
+	}
+	if len(stats.Diagnostics) == 0 {
+		t.Fatalf("Expected diagnostics to be recorded in stats")
+	}
+	if stats.Sources[invalidFile] == nil {
+		t.Fatalf("Expected source of %s to be recorded in stats", invalidFile)
+	}
+
+	var buf bytes.Buffer
+	if err := writeDiagnostics(&buf, stats.Sources, stats.Diagnostics, false); err != nil {
+		t.Fatalf("writeDiagnostics failed: %v", err)
+	}
+	out := buf.String()
+	t.Logf("Rendered diagnostics:\n%s", out)
+
+	if !strings.Contains(out, "Error: ") {
+		t.Errorf("Expected severity in output")
+	}
+	if !strings.Contains(out, "   7:   to   = = aws_instance.web\n") {
+		t.Errorf("Expected offending line in output")
+	}
+	if !strings.Contains(out, "\n"+strings.Repeat(" ", 15)+"^\n") {
+		t.Errorf("Expected caret under the offending token")
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("Expected no escape sequences when colour is disabled")
+	}
+
+	buf.Reset()
+	if err := writeDiagnostics(&buf, stats.Sources, stats.Diagnostics, true); err != nil {
+		t.Fatalf("writeDiagnostics failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "\x1b[31m") {
+		t.Errorf("Expected escape sequences when colour is enabled")
+	}
+}
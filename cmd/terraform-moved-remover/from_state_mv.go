@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// stateMvOptionsWithValue lists the options of terraform state mv that take a
+// value, so "-state path" consumes the following word
+var stateMvOptionsWithValue = map[string]bool{
+	"-state":        true,
+	"-state-out":    true,
+	"-backup":       true,
+	"-backup-out":   true,
+	"-lock-timeout": true,
+}
+
+// stateMove is a terraform state mv command found in a script
+type stateMove struct {
+	Source string // script name and line, for reporting
+	From   string
+	To     string
+}
+
+// runFromStateMv implements the from-state-mv subcommand
+func runFromStateMv(args []string) int {
+	fs := flag.NewFlagSet("from-state-mv", flag.ExitOnError)
+	rootFlag := fs.String("root", ".", "Root module directory that the state addresses are relative to")
+	outFlag := fs.String("out", defaultMovedFile, "File in each module directory that moved blocks are written to")
+	dryRunFlag := fs.Bool("dry-run", false, "Print the moved blocks without writing them")
+	fs.Usage = func() {
+		fmt.Println("Usage: terraform-moved-remover from-state-mv [options] [script...]")
+		fmt.Println("       Converts 'terraform state mv' commands in shell scripts into moved")
+		fmt.Println("       blocks. Reads the script from stdin if none is given.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	scripts := fs.Args()
+	if len(scripts) == 0 {
+		scripts = []string{stdinPath}
+	}
+
+	var moves []stateMove
+	for _, script := range scripts {
+		var content []byte
+		var err error
+		if script == stdinPath {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(script)
+		}
+		if err != nil {
+			fmt.Printf("Error reading %s: %s\n", script, err)
+			return 1
+		}
+
+		found, warnings, err := parseStateMvScript(script, string(content))
+		if err != nil {
+			fmt.Printf("Error parsing %s: %s\n", script, err)
+			return 1
+		}
+		for _, warning := range warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+		moves = append(moves, found...)
+	}
+
+	placed, warnings, err := placeStateMoves(*rootFlag, moves)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	fmt.Printf("Found %d state mv commands\n", len(moves))
+	for _, move := range placed {
+		fmt.Printf("  %s: %s -> %s\n", moduleLabel(move.Module), move.From, move.To)
+	}
+
+	if *dryRunFlag || len(placed) == 0 {
+		return 0
+	}
+
+	written, err := writeMovedBlocks(*rootFlag, *outFlag, placed)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	fmt.Printf("\nWrote moved blocks to %d files\n", len(written))
+	for _, file := range written {
+		fmt.Printf("  %s\n", file)
+	}
+	return 0
+}
+
+// parseStateMvScript extracts terraform state mv commands from a shell
+// script. Commands that cannot be converted are reported as warnings.
+func parseStateMvScript(name, script string) ([]stateMove, []string, error) {
+	commands, err := parseShellScript(script)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var moves []stateMove
+	var warnings []string
+	for _, cmd := range commands {
+		args, ok := stateMvArgs(cmd.Args)
+		if !ok {
+			continue
+		}
+		source := fmt.Sprintf("%s:%d", name, cmd.Line)
+
+		if cmd.Expanded {
+			warnings = append(warnings, fmt.Sprintf("%s: skipping command that uses shell expansion", source))
+			continue
+		}
+
+		var positional []string
+		for i := 0; i < len(args); i++ {
+			arg := args[i]
+			if !strings.HasPrefix(arg, "-") {
+				positional = append(positional, arg)
+				continue
+			}
+			if stateMvOptionsWithValue[arg] {
+				i++
+			}
+		}
+		if len(positional) != 2 {
+			warnings = append(warnings, fmt.Sprintf("%s: expected a source and destination address, got %d arguments", source, len(positional)))
+			continue
+		}
+
+		moves = append(moves, stateMove{Source: source, From: positional[0], To: positional[1]})
+	}
+	return moves, warnings, nil
+}
+
+// stateMvArgs returns the arguments following "terraform state mv" if the
+// command is one. Wrappers before terraform and global options such as
+// -chdir are allowed.
+func stateMvArgs(args []string) ([]string, bool) {
+	for i, arg := range args {
+		base := filepath.Base(arg)
+		if base != "terraform" && base != "tofu" {
+			continue
+		}
+		rest := args[i+1:]
+		for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+			rest = rest[1:]
+		}
+		if len(rest) >= 2 && rest[0] == "state" && rest[1] == "mv" {
+			return rest[2:], true
+		}
+	}
+	return nil, false
+}
+
+// placeStateMoves decides which module each move belongs to. Moves whose
+// addresses share a leading module call without instance keys are placed in
+// that module's directory, relative to it. Everything else stays in the root
+// module. Moves through module calls that cannot be resolved, such as calls
+// renamed since the script was written, are skipped and reported as
+// warnings.
+func placeStateMoves(rootDir string, moves []stateMove) ([]detectedMove, []string, error) {
+	var placed []detectedMove
+	var warnings []string
+	for _, move := range moves {
+		from, err := remover.ParseAddress(move.From)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", move.Source, err)
+		}
+		to, err := remover.ParseAddress(move.To)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", move.Source, err)
+		}
+
+		common := 0
+		for common < len(from.Module) && common < len(to.Module) &&
+			from.Module[common] == to.Module[common] && from.Module[common].Key == "" {
+			common++
+		}
+		// A move of a whole module call must stay in the calling module
+		for common > 0 && (common == len(from.Module) && from.IsModule() || common == len(to.Module) && to.IsModule()) {
+			common--
+		}
+
+		module := "."
+		if common > 0 {
+			calls := make([]string, common)
+			for i, step := range from.Module[:common] {
+				calls[i] = step.Name
+			}
+			dir, err := resolveModulePath(rootDir, calls)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: skipping move %s -> %s: %s", move.Source, move.From, move.To, err))
+				continue
+			}
+			rel, err := filepath.Rel(rootDir, dir)
+			if err != nil {
+				return nil, nil, err
+			}
+			module = filepath.ToSlash(rel)
+		}
+
+		from.Module = from.Module[common:]
+		to.Module = to.Module[common:]
+		placed = append(placed, detectedMove{
+			Module: module,
+			From:   from.String(),
+			To:     to.String(),
+		})
+	}
+	return placed, warnings, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseStateMvScript tests extracting state mv commands from scripts
+func TestParseStateMvScript(t *testing.T) {
+	script := `#!/bin/sh
+set -e
+
+# rename the web servers
+terraform state mv aws_instance.web aws_instance.web_server
+terraform state mv -state=prod.tfstate 'aws_instance.web[0]' 'aws_instance.app[0]'
+terraform -chdir=infra state mv -lock=false \
+  'module.db["primary"].aws_db_instance.this' \
+  "module.db[\"main\"].aws_db_instance.this"
+terraform state mv -state other.tfstate module.network module.vpc && echo done
+terraform state list
+terraform state mv "$FROM" "$TO"
+terraform state mv aws_instance.only_one
+`
+	moves, warnings, err := parseStateMvScript("runbook.sh", script)
+	if err != nil {
+		t.Fatalf("parseStateMvScript failed: %v", err)
+	}
+
+	expected := []stateMove{
+		{Source: "runbook.sh:5", From: "aws_instance.web", To: "aws_instance.web_server"},
+		{Source: "runbook.sh:6", From: "aws_instance.web[0]", To: "aws_instance.app[0]"},
+		{Source: "runbook.sh:7", From: `module.db["primary"].aws_db_instance.this`, To: `module.db["main"].aws_db_instance.this`},
+		{Source: "runbook.sh:10", From: "module.network", To: "module.vpc"},
+	}
+	if len(moves) != len(expected) {
+		t.Fatalf("Expected %d moves, but got %v", len(expected), moves)
+	}
+	for i := range expected {
+		if moves[i] != expected[i] {
+			t.Errorf("Expected move %v, but got %v", expected[i], moves[i])
+		}
+	}
+
+	if len(warnings) != 2 {
+		t.Errorf("Expected 2 warnings, but got %v", warnings)
+	}
+}
+
+// TestPlaceStateMoves tests that moves are written to the module they belong to
+func TestPlaceStateMoves(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"main.tf": `
+module "app" {
+  source = "./modules/app"
+}
+`,
+		"modules/app/main.tf": `
+module "db" {
+  source = "../db"
+}
+
+resource "aws_instance" "web_server" {}
+`,
+		"modules/db/main.tf": `resource "aws_db_instance" "this" {}` + "\n",
+	})
+
+	moves := []stateMove{
+		{From: "aws_instance.web[0]", To: "aws_instance.app[0]"},
+		{From: "module.app.aws_instance.web", To: "module.app.aws_instance.web_server"},
+		{From: "module.app.module.db.aws_db_instance.old", To: "module.app.module.db.aws_db_instance.this"},
+		{From: "module.app.module.database", To: "module.app.module.db"},
+		{Source: "s.sh:7", From: "module.network.aws_vpc.a", To: "module.network.aws_vpc.b"},
+		{From: `module.app.module.db["a"].aws_db_instance.x`, To: `module.app.module.db["a"].aws_db_instance.y`},
+		{From: "aws_instance.legacy", To: "module.app.aws_instance.legacy"},
+	}
+	placed, warnings, err := placeStateMoves(rootDir, moves)
+	if err != nil {
+		t.Fatalf("placeStateMoves failed: %v", err)
+	}
+	// The network module call was renamed since, so only that move is skipped
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], `s.sh:7: skipping move module.network.aws_vpc.a -> module.network.aws_vpc.b: module "network" is not a local module call`) {
+		t.Errorf("Expected a warning for the unresolvable module call, but got %v", warnings)
+	}
+
+	expected := []detectedMove{
+		{Module: ".", From: "aws_instance.web[0]", To: "aws_instance.app[0]"},
+		{Module: "modules/app", From: "aws_instance.web", To: "aws_instance.web_server"},
+		{Module: "modules/db", From: "aws_db_instance.old", To: "aws_db_instance.this"},
+		{Module: "modules/app", From: "module.database", To: "module.db"},
+		{Module: "modules/app", From: `module.db["a"].aws_db_instance.x`, To: `module.db["a"].aws_db_instance.y`},
+		{Module: ".", From: "aws_instance.legacy", To: "module.app.aws_instance.legacy"},
+	}
+	if len(placed) != len(expected) {
+		t.Fatalf("Expected %d moves, but got %v", len(expected), placed)
+	}
+	for i := range expected {
+		if placed[i] != expected[i] {
+			t.Errorf("Expected move %v, but got %v", expected[i], placed[i])
+		}
+	}
+
+	if _, err := writeMovedBlocks(rootDir, defaultMovedFile, placed); err != nil {
+		t.Fatalf("writeMovedBlocks failed: %v", err)
+	}
+	// Writing the same moves again must not duplicate them
+	if _, err := writeMovedBlocks(rootDir, defaultMovedFile, placed); err != nil {
+		t.Fatalf("writeMovedBlocks failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(rootDir, "modules", "db", defaultMovedFile))
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	expectedContent := `moved {
+  from = aws_db_instance.old
+  to   = aws_db_instance.this
+}
+`
+	if string(content) != expectedContent {
+		t.Errorf("Expected content:\n%s\nActual content:\n%s", expectedContent, string(content))
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// defaultMovedFile is the file that generated moved blocks are written to
+const defaultMovedFile = "moved.tf"
+
+// configSnapshot holds the Terraform files of a tree, keyed by module
+// directory (slash separated, relative to the tree root) and file name
+type configSnapshot map[string]map[string][]byte
+
+// moduleObject is a resource or module call declared in a module directory
+type moduleObject struct {
+	Address     string
+	Kind        string // resource type, or "module" for module calls
+	Fingerprint string
+}
+
+// detectedMove is a rename found between two snapshots
+type detectedMove struct {
+	Module string
+	From   string
+	To     string
+}
+
+// ambiguousMove is a removed object with no unique counterpart
+type ambiguousMove struct {
+	Module     string
+	From       string
+	Candidates []string
+}
+
+// runGenerate implements the generate subcommand
+func runGenerate(args []string) int {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	dirFlag := fs.String("dir", ".", "Repository directory used to resolve git revisions")
+	outFlag := fs.String("out", defaultMovedFile, "File in each module directory that moved blocks are written to")
+	dryRunFlag := fs.Bool("dry-run", false, "Print the moved blocks without writing them")
+	fs.Usage = func() {
+		fmt.Println("Usage: terraform-moved-remover generate [options] OLD NEW")
+		fmt.Println("       OLD and NEW are directories or git revisions. Renamed resources and")
+		fmt.Println("       module calls are detected and moved blocks are written to NEW, or to")
+		fmt.Println("       -dir when NEW is a revision.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 1
+	}
+
+	oldSnap, _, err := loadSnapshot(fs.Arg(0), *dirFlag)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	newSnap, targetDir, err := loadSnapshot(fs.Arg(1), *dirFlag)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	moves, ambiguous, err := detectMoves(oldSnap, newSnap)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("Detected %d moves\n", len(moves))
+	for _, move := range moves {
+		fmt.Printf("  %s: %s -> %s\n", moduleLabel(move.Module), move.From, move.To)
+	}
+
+	if len(ambiguous) > 0 {
+		fmt.Printf("\nAmbiguous matches (no moved block written): %d\n", len(ambiguous))
+		for _, amb := range ambiguous {
+			fmt.Printf("  %s: %s could be any of %s\n", moduleLabel(amb.Module), amb.From, strings.Join(amb.Candidates, ", "))
+		}
+	}
+
+	if *dryRunFlag || len(moves) == 0 {
+		return 0
+	}
+
+	written, err := writeMovedBlocks(targetDir, *outFlag, moves)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	fmt.Printf("\nWrote moved blocks to %d files\n", len(written))
+	for _, file := range written {
+		fmt.Printf("  %s\n", file)
+	}
+	return 0
+}
+
+// moduleLabel returns a readable name for a module directory
+func moduleLabel(dir string) string {
+	if dir == "." {
+		return "(root)"
+	}
+	return dir
+}
+
+// loadSnapshot reads the Terraform files of a directory or git revision. It
+// also returns the directory that moved blocks for the snapshot are written
+// to.
+func loadSnapshot(ref, repoDir string) (configSnapshot, string, error) {
+	if info, err := os.Stat(ref); err == nil && info.IsDir() {
+		snap, err := loadDirSnapshot(ref)
+		return snap, ref, err
+	}
+	snap, err := loadGitSnapshot(repoDir, ref)
+	return snap, repoDir, err
+}
+
+// loadDirSnapshot reads the Terraform files below rootDir
+func loadDirSnapshot(rootDir string) (configSnapshot, error) {
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := make(configSnapshot)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s: %w", file, err)
+		}
+		rel, err := filepath.Rel(rootDir, file)
+		if err != nil {
+			return nil, err
+		}
+		snap.add(filepath.ToSlash(rel), content)
+	}
+	return snap, nil
+}
+
+// loadGitSnapshot reads the Terraform files of a revision below repoDir
+func loadGitSnapshot(repoDir, rev string) (configSnapshot, error) {
+	out, err := gitOutput(repoDir, "ls-tree", "-r", "-z", "--name-only", rev)
+	if err != nil {
+		return nil, fmt.Errorf("%s is neither a directory nor a git revision: %w", rev, err)
+	}
+
+	snap := make(configSnapshot)
+	for _, name := range strings.Split(string(out), "\x00") {
+		if !strings.HasSuffix(name, ".tf") {
+			continue
+		}
+		content, err := gitOutput(repoDir, "show", rev+":./"+name)
+		if err != nil {
+			return nil, err
+		}
+		snap.add(name, content)
+	}
+	return snap, nil
+}
+
+// gitOutput runs git in dir and returns its standard output
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (s configSnapshot) add(name string, content []byte) {
+	dir := path.Dir(name)
+	if s[dir] == nil {
+		s[dir] = make(map[string][]byte)
+	}
+	s[dir][path.Base(name)] = content
+}
+
+// moduleObjects returns the resources and module calls declared in a module
+// directory, along with the moves its moved blocks already declare
+func (s configSnapshot) moduleObjects(dir string) (map[string]moduleObject, map[string]string, error) {
+	objects := make(map[string]moduleObject)
+	declared := make(map[string]string)
+
+	names := make([]string, 0, len(s[dir]))
+	for name := range s[dir] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		filePath := path.Join(dir, name)
+		file, diags := hclwrite.ParseConfig(s[dir][name], filePath, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			return nil, nil, &remover.DiagnosticsError{Path: filePath, Diagnostics: diags}
+		}
+
+		for _, block := range file.Body().Blocks() {
+			labels := block.Labels()
+			switch {
+			case block.Type() == "resource" && len(labels) == 2:
+				objects[labels[0]+"."+labels[1]] = moduleObject{
+					Address:     labels[0] + "." + labels[1],
+					Kind:        labels[0],
+					Fingerprint: bodyFingerprint(block.Body()),
+				}
+			case block.Type() == "module" && len(labels) == 1:
+				objects["module."+labels[0]] = moduleObject{
+					Address:     "module." + labels[0],
+					Kind:        "module",
+					Fingerprint: attributeText(block, "source"),
+				}
+			case block.Type() == "moved":
+				declared[remover.NormalizeAddress(attributeText(block, "from"))] = remover.NormalizeAddress(attributeText(block, "to"))
+			}
+		}
+	}
+	return objects, declared, nil
+}
+
+// bodyFingerprint returns the formatted text of a block body so bodies that
+// differ only in whitespace compare equal
+func bodyFingerprint(body *hclwrite.Body) string {
+	return string(hclwrite.Format(body.BuildTokens(nil).Bytes()))
+}
+
+// attributeText returns the source text of an attribute's expression, or an
+// empty string if the attribute is not set
+func attributeText(block *hclwrite.Block, name string) string {
+	attr := block.Body().GetAttribute(name)
+	if attr == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes()))
+}
+
+// detectMoves compares two snapshots module by module. A resource is
+// considered renamed when it disappeared and exactly one new resource of the
+// same type has the same body; a module call is considered renamed when
+// exactly one new call has the same source. Anything else is reported as
+// ambiguous rather than guessed.
+func detectMoves(oldSnap, newSnap configSnapshot) ([]detectedMove, []ambiguousMove, error) {
+	var moves []detectedMove
+	var ambiguous []ambiguousMove
+
+	dirs := make([]string, 0, len(newSnap))
+	for dir := range newSnap {
+		if _, ok := oldSnap[dir]; ok {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		oldObjects, _, err := oldSnap.moduleObjects(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		newObjects, declared, err := newSnap.moduleObjects(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var removed, added []moduleObject
+		for addr, obj := range oldObjects {
+			if _, ok := newObjects[addr]; !ok {
+				removed = append(removed, obj)
+			}
+		}
+		for addr, obj := range newObjects {
+			if _, ok := oldObjects[addr]; !ok {
+				added = append(added, obj)
+			}
+		}
+		sort.Slice(removed, func(i, j int) bool { return removed[i].Address < removed[j].Address })
+		sort.Slice(added, func(i, j int) bool { return added[i].Address < added[j].Address })
+
+		candidates := make(map[string][]string)
+		claims := make(map[string]int)
+		for _, from := range removed {
+			for _, to := range added {
+				if from.Kind == to.Kind && from.Fingerprint == to.Fingerprint {
+					candidates[from.Address] = append(candidates[from.Address], to.Address)
+					claims[to.Address]++
+				}
+			}
+		}
+
+		for _, from := range removed {
+			matches := candidates[from.Address]
+			switch {
+			case len(matches) == 0:
+				continue
+			case len(matches) == 1 && claims[matches[0]] == 1:
+				if declared[from.Address] == matches[0] {
+					continue
+				}
+				moves = append(moves, detectedMove{Module: dir, From: from.Address, To: matches[0]})
+			default:
+				ambiguous = append(ambiguous, ambiguousMove{Module: dir, From: from.Address, Candidates: matches})
+			}
+		}
+	}
+
+	return moves, ambiguous, nil
+}
+
+// writeMovedBlocks appends moved blocks to the named file in each module
+// directory below rootDir, creating the file if needed. Moves the file already
+// declares are skipped. It returns the paths of the files written.
+func writeMovedBlocks(rootDir, fileName string, moves []detectedMove) ([]string, error) {
+	byModule := make(map[string][]detectedMove)
+	var dirs []string
+	for _, move := range moves {
+		if _, ok := byModule[move.Module]; !ok {
+			dirs = append(dirs, move.Module)
+		}
+		byModule[move.Module] = append(byModule[move.Module], move)
+	}
+
+	var written []string
+	for _, dir := range dirs {
+		filePath := filepath.Join(rootDir, filepath.FromSlash(dir), fileName)
+
+		content, err := os.ReadFile(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return written, fmt.Errorf("error reading file %s: %w", filePath, err)
+		}
+
+		file, diags := hclwrite.ParseConfig(content, filePath, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			return written, &remover.DiagnosticsError{Path: filePath, Diagnostics: diags}
+		}
+
+		existing := make(map[detectedMove]bool)
+		for _, block := range file.Body().Blocks() {
+			if block.Type() == "moved" {
+				existing[detectedMove{
+					Module: dir,
+					From:   remover.NormalizeAddress(attributeText(block, "from")),
+					To:     remover.NormalizeAddress(attributeText(block, "to")),
+				}] = true
+			}
+		}
+
+		for _, move := range byModule[dir] {
+			key := detectedMove{Module: dir, From: remover.NormalizeAddress(move.From), To: remover.NormalizeAddress(move.To)}
+			if existing[key] {
+				continue
+			}
+			if err := appendMovedBlock(file.Body(), move.From, move.To); err != nil {
+				return written, err
+			}
+		}
+
+		if err := os.WriteFile(filePath, hclwrite.Format(file.Bytes()), 0644); err != nil {
+			return written, fmt.Errorf("error writing file %s: %w", filePath, err)
+		}
+		written = append(written, filePath)
+	}
+	return written, nil
+}
+
+// appendMovedBlock appends a moved block for the given addresses, separated
+// from any previous content by a blank line
+func appendMovedBlock(body *hclwrite.Body, from, to string) error {
+	fromTraversal, diags := hclsyntax.ParseTraversalAbs([]byte(from), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return fmt.Errorf("invalid address %q: %s", from, diags.Error())
+	}
+	toTraversal, diags := hclsyntax.ParseTraversalAbs([]byte(to), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return fmt.Errorf("invalid address %q: %s", to, diags.Error())
+	}
+
+	if len(body.Attributes()) > 0 || len(body.Blocks()) > 0 {
+		body.AppendNewline()
+	}
+	block := body.AppendNewBlock("moved", nil)
+	block.Body().SetAttributeTraversal("from", fromTraversal)
+	block.Body().SetAttributeTraversal("to", toTraversal)
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", path, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write test file %s: %v", path, err)
+		}
+	}
+}
+
+// TestDetectMoves tests rename detection between two directories
+func TestDetectMoves(t *testing.T) {
+	oldDir := t.TempDir()
+	newDir := t.TempDir()
+
+	writeTestFiles(t, oldDir, map[string]string{
+		"main.tf": `
+resource "aws_instance" "web" {
+  ami = "ami-123456"
+}
+
+resource "aws_s3_bucket" "a" {
+  bucket = "shared"
+}
+
+resource "aws_s3_bucket" "b" {
+  bucket = "shared"
+}
+
+module "network" {
+  source = "./modules/vpc"
+}
+`,
+		"modules/vpc/main.tf": `
+resource "aws_vpc" "primary" {
+  cidr_block = "10.0.0.0/16"
+}
+`,
+	})
+	writeTestFiles(t, newDir, map[string]string{
+		"main.tf": `
+resource "aws_instance" "web_server" {
+  ami   =   "ami-123456"
+}
+
+resource "aws_s3_bucket" "c" {
+  bucket = "shared"
+}
+
+resource "aws_s3_bucket" "d" {
+  bucket = "shared"
+}
+
+module "vpc" {
+  source = "./modules/vpc"
+}
+`,
+		"modules/vpc/main.tf": `
+resource "aws_vpc" "main" {
+  cidr_block = "10.0.0.0/16"
+}
+
+moved {
+  from = aws_vpc.primary
+  to   = aws_vpc.main
+}
+`,
+	})
+
+	oldSnap, err := loadDirSnapshot(oldDir)
+	if err != nil {
+		t.Fatalf("loadDirSnapshot failed: %v", err)
+	}
+	newSnap, err := loadDirSnapshot(newDir)
+	if err != nil {
+		t.Fatalf("loadDirSnapshot failed: %v", err)
+	}
+
+	moves, ambiguous, err := detectMoves(oldSnap, newSnap)
+	if err != nil {
+		t.Fatalf("detectMoves failed: %v", err)
+	}
+
+	expected := []detectedMove{
+		{Module: ".", From: "aws_instance.web", To: "aws_instance.web_server"},
+		{Module: ".", From: "module.network", To: "module.vpc"},
+	}
+	if len(moves) != len(expected) {
+		t.Fatalf("Expected %d moves, but got %v", len(expected), moves)
+	}
+	for i := range expected {
+		if moves[i] != expected[i] {
+			t.Errorf("Expected move %v, but got %v", expected[i], moves[i])
+		}
+	}
+
+	// Both buckets have identical bodies, so their renames are ambiguous
+	if len(ambiguous) != 2 {
+		t.Fatalf("Expected 2 ambiguous matches, but got %v", ambiguous)
+	}
+	if ambiguous[0].From != "aws_s3_bucket.a" || len(ambiguous[0].Candidates) != 2 {
+		t.Errorf("Unexpected ambiguous match %v", ambiguous[0])
+	}
+
+	written, err := writeMovedBlocks(newDir, defaultMovedFile, moves)
+	if err != nil {
+		t.Fatalf("writeMovedBlocks failed: %v", err)
+	}
+	if len(written) != 1 {
+		t.Fatalf("Expected 1 file to be written, but got %v", written)
+	}
+
+	content, err := os.ReadFile(filepath.Join(newDir, defaultMovedFile))
+	if err != nil {
+		t.Fatalf("Failed to read generated file: %v", err)
+	}
+	expectedContent := `moved {
+  from = aws_instance.web
+  to   = aws_instance.web_server
+}
+
+moved {
+  from = module.network
+  to   = module.vpc
+}
+`
+	if string(content) != expectedContent {
+		t.Errorf("Expected content:\n%s\nActual content:\n%s", expectedContent, string(content))
+	}
+}
+
+// TestLoadGitSnapshot tests reading Terraform files from a git revision
+func TestLoadGitSnapshot(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repoDir := t.TempDir()
+	writeTestFiles(t, repoDir, map[string]string{
+		"infra/main.tf":        `resource "aws_instance" "web" {}` + "\n",
+		"infra/modules/a/a.tf": `resource "aws_instance" "a" {}` + "\n",
+		"README.md":            "not terraform\n",
+	})
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		if _, err := gitOutput(repoDir, args...); err != nil {
+			t.Fatalf("git failed: %v", err)
+		}
+	}
+
+	snap, err := loadGitSnapshot(filepath.Join(repoDir, "infra"), "HEAD")
+	if err != nil {
+		t.Fatalf("loadGitSnapshot failed: %v", err)
+	}
+	if !strings.Contains(string(snap["."]["main.tf"]), "aws_instance") {
+		t.Errorf("Expected main.tf in root module, got %v", snap)
+	}
+	if _, ok := snap["modules/a"]["a.tf"]; !ok {
+		t.Errorf("Expected modules/a/a.tf in snapshot, got %v", snap)
+	}
+
+	if _, err := loadGitSnapshot(repoDir, "no-such-revision"); err == nil {
+		t.Errorf("Expected error for unknown revision")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// defaultCommitBranch is the branch -git-commit creates by default
+const defaultCommitBranch = "remove-moved-blocks"
+
+// defaultCommitTemplate is the default commit message template. It is
+// executed with a commitData value.
+const defaultCommitTemplate = `Remove {{.BlocksRemoved}} applied moved blocks
+{{range .Files}}
+{{.Path}}:
+{{- range .Moves}}
+  {{.From}} -> {{.To}}
+{{- else}}
+  (formatting only)
+{{- end}}
+{{end}}`
+
+// commitData is passed to the commit message template
+type commitData struct {
+	Branch        string
+	FilesModified int
+	BlocksRemoved int
+	Files         []commitFile
+}
+
+// commitFile lists the moves removed from one file. Path is relative to the
+// repository root.
+type commitFile struct {
+	Path  string
+	Moves []RemovedBlock
+}
+
+// gitCommitter commits the changes of a run to a git repository
+type gitCommitter struct {
+	// Repo is the top level directory of the repository
+	Repo     string
+	Branch   string
+	Template *template.Template
+}
+
+// newGitCommitter finds the repository containing rootDir and checks that
+// the run can be committed: the working tree must have no changes to
+// tracked files unless force is set, and the branch must not exist yet
+func newGitCommitter(rootDir, branch, messageTemplate string, force bool) (*gitCommitter, error) {
+	tmpl, err := template.New("message").Parse(messageTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit message template: %w", err)
+	}
+
+	out, err := gitOutput(rootDir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	c := &gitCommitter{Repo: strings.TrimSpace(string(out)), Branch: branch, Template: tmpl}
+
+	if !force {
+		status, err := gitOutput(c.Repo, "status", "--porcelain", "--untracked-files=no")
+		if err != nil {
+			return nil, err
+		}
+		if len(strings.TrimSpace(string(status))) > 0 {
+			return nil, fmt.Errorf("working tree of %s has uncommitted changes (use -force to commit anyway)", c.Repo)
+		}
+	}
+
+	if branch != "" {
+		if _, err := gitOutput(c.Repo, "check-ref-format", "--branch", branch); err != nil {
+			return nil, fmt.Errorf("invalid branch name %q", branch)
+		}
+		if _, err := gitOutput(c.Repo, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err == nil {
+			return nil, fmt.Errorf("branch %s already exists", branch)
+		}
+	}
+	return c, nil
+}
+
+// message renders the commit message for the files modified in a run
+func (c *gitCommitter) message(stats *Stats, modified []string) (string, error) {
+	data := commitData{
+		Branch:        c.Branch,
+		FilesModified: len(modified),
+		BlocksRemoved: stats.MovedBlocksRemoved,
+	}
+	for _, path := range modified {
+		file := commitFile{Path: c.relative(path)}
+		for _, block := range stats.RemovedBlocks {
+			if block.Path == path {
+				file.Moves = append(file.Moves, block)
+			}
+		}
+		data.Files = append(data.Files, file)
+	}
+
+	var b strings.Builder
+	if err := c.Template.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error rendering commit message: %w", err)
+	}
+	return strings.TrimSpace(b.String()) + "\n", nil
+}
+
+// relative returns path relative to the repository root, with slashes
+func (c *gitCommitter) relative(path string) string {
+	return repoRelative(c.Repo, path)
+}
+
+// repoRelative returns path relative to the top level directory of a
+// repository, with slashes, as git prints paths
+func repoRelative(repo, path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	if resolved, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		abs = filepath.Join(resolved, filepath.Base(abs))
+	}
+	rel, err := filepath.Rel(repo, abs)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// commit creates the branch, if any, and commits the modified files. It
+// returns the hash of the new commit, or an empty string when nothing was
+// modified.
+func (c *gitCommitter) commit(stats *Stats) (string, error) {
+	var modified []string
+	for _, file := range stats.Files {
+		if file.changed() {
+			modified = append(modified, file.Path)
+		}
+	}
+	if len(modified) == 0 {
+		return "", nil
+	}
+
+	message, err := c.message(stats, modified)
+	if err != nil {
+		return "", err
+	}
+
+	paths := make([]string, 0, len(modified))
+	for _, path := range modified {
+		paths = append(paths, c.relative(path))
+	}
+
+	if c.Branch != "" {
+		if _, err := gitOutput(c.Repo, "checkout", "-q", "-b", c.Branch); err != nil {
+			return "", err
+		}
+	}
+	if _, err := gitOutput(c.Repo, append([]string{"add", "--"}, paths...)...); err != nil {
+		return "", err
+	}
+	// Only commit the files we modified, even if other changes are staged
+	if _, err := gitOutput(c.Repo, append([]string{"commit", "-q", "-m", message, "--"}, paths...)...); err != nil {
+		return "", err
+	}
+	out, err := gitOutput(c.Repo, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGitCommit tests committing a run on a new branch with a generated
+// message
+func TestGitCommit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repoDir := t.TempDir()
+	writeTestFiles(t, repoDir, map[string]string{
+		"infra/main.tf": `resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`,
+		"infra/vpc.tf": "resource \"aws_vpc\" \"main\" {}\n",
+	})
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := gitOutput(repoDir, args...)
+		if err != nil {
+			t.Fatalf("git failed: %v", err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("config", "user.name", "test")
+	git("config", "user.email", "test@example.com")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+
+	rootDir := filepath.Join(repoDir, "infra")
+
+	// A dirty working tree is refused unless forced
+	vpc := filepath.Join(rootDir, "vpc.tf")
+	if err := os.WriteFile(vpc, []byte("resource \"aws_vpc\" \"other\" {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := newGitCommitter(rootDir, defaultCommitBranch, defaultCommitTemplate, false); err == nil {
+		t.Errorf("Expected an error for a dirty working tree")
+	}
+	if _, err := newGitCommitter(rootDir, defaultCommitBranch, defaultCommitTemplate, true); err != nil {
+		t.Errorf("Expected -force to allow a dirty working tree, but got %v", err)
+	}
+	git("checkout", "-q", "--", ".")
+
+	// Untracked files, such as the journal, do not make the tree dirty
+	writeTestFiles(t, rootDir, map[string]string{".moved-remover/journal.json": "{}\n"})
+	committer, err := newGitCommitter(rootDir, defaultCommitBranch, defaultCommitTemplate, false)
+	if err != nil {
+		t.Fatalf("newGitCommitter failed: %v", err)
+	}
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	stats := Stats{}
+	processFiles(files, &stats)
+
+	hash, err := committer.commit(&stats)
+	if err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	if hash == "" {
+		t.Fatalf("Expected a commit to be made")
+	}
+
+	if branch := git("rev-parse", "--abbrev-ref", "HEAD"); branch != defaultCommitBranch {
+		t.Errorf("Expected branch %s, but got %s", defaultCommitBranch, branch)
+	}
+	expectedMessage := `Remove 1 applied moved blocks
+
+infra/main.tf:
+  aws_instance.old -> aws_instance.web`
+	if message := git("log", "-1", "--format=%B"); message != expectedMessage {
+		t.Errorf("Expected message:\n%s\nActual message:\n%s", expectedMessage, message)
+	}
+	if changed := git("show", "--name-only", "--format="); changed != "infra/main.tf" {
+		t.Errorf("Expected only infra/main.tf to be committed, but got %q", changed)
+	}
+
+	// The branch exists now, so a second run is refused
+	if _, err := newGitCommitter(rootDir, defaultCommitBranch, defaultCommitTemplate, false); err == nil {
+		t.Errorf("Expected an error for an existing branch")
+	}
+	if _, err := newGitCommitter(rootDir, "", "{{.Broken", false); err == nil {
+		t.Errorf("Expected an error for an invalid template")
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestIntegration performs integration testing with various Terraform file structures
@@ -260,3 +262,226 @@ resource "aws_instance" "web" {
 		}
 	}
 }
+
+// fakeTerraform is a stand-in for terraform used to test verification
+// offline. "terraform plan -detailed-exitcode" reports changes, exit status
+// 2, in any module that contains the comment "# plan-changes", and no
+// changes otherwise.
+const fakeTerraform = `#!/bin/sh
+if [ "$1" != "plan" ] || [ "$2" != "-detailed-exitcode" ]; then
+  echo "unexpected arguments: $*" >&2
+  exit 1
+fi
+if grep -q "# plan-changes" ./*.tf 2>/dev/null; then
+  echo "Plan: 1 to add, 0 to change, 1 to destroy."
+  exit 2
+fi
+echo "No changes. Your infrastructure matches the configuration."
+exit 0
+`
+
+// TestVerifyRollback tests that modules whose verification fails are rolled
+// back to their pre-run content while verified modules keep their changes
+func TestVerifyRollback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake terraform is a shell script")
+	}
+
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "terraform"), []byte(fakeTerraform), 0755); err != nil {
+		t.Fatalf("Failed to write fake terraform: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	testDir := t.TempDir()
+	applied := `resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+	pending := `# plan-changes
+resource "aws_s3_bucket" "data" {}
+
+moved {
+  from = aws_s3_bucket.logs
+  to   = aws_s3_bucket.data
+}
+`
+	writeTestFiles(t, testDir, map[string]string{
+		"applied/main.tf": applied,
+		"pending/main.tf": pending,
+		"pending/vars.tf": "variable  \"x\" {}\n",
+	})
+
+	args, err := newVerifyCommand("terraform plan -detailed-exitcode")
+	if err != nil {
+		t.Fatalf("newVerifyCommand failed: %v", err)
+	}
+
+	files, err := findTerraformFiles(testDir)
+	if err != nil {
+		t.Fatalf("Failed to find Terraform files: %v", err)
+	}
+	graph, err := newReconciler(testDir, files, nil, nil)
+	if err != nil {
+		t.Fatalf("newReconciler failed: %v", err)
+	}
+	stats := Stats{Journal: newJournal(testDir), Originals: make(map[string][]byte)}
+	processFiles(files, &stats)
+	results := verifyModules(args, time.Minute, graph, &stats)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 verified modules, but got %d", len(results))
+	}
+	if results[0].Err != nil {
+		t.Errorf("Expected verification of %s to pass, but got %v", results[0].Dir, results[0].Err)
+	}
+	if results[1].Err == nil || len(results[1].RolledBack) != 2 {
+		t.Errorf("Expected verification of %s to fail and roll back 2 files, but got %+v", results[1].Dir, results[1])
+	}
+	if !strings.Contains(string(results[1].Output), "1 to destroy") {
+		t.Errorf("Expected the plan output to be kept, but got %q", results[1].Output)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(testDir, "applied", "main.tf"))
+	if strings.Contains(string(content), "moved {") {
+		t.Errorf("Expected the verified module to keep its changes, but got:\n%s", content)
+	}
+	content, _ = os.ReadFile(filepath.Join(testDir, "pending", "main.tf"))
+	if string(content) != pending {
+		t.Errorf("Expected the failed module to be rolled back, but got:\n%s", content)
+	}
+
+	if stats.FilesModified != 1 {
+		t.Errorf("Expected FilesModified to be 1, but got %d", stats.FilesModified)
+	}
+	if stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected MovedBlocksRemoved to be 1, but got %d", stats.MovedBlocksRemoved)
+	}
+	if len(stats.Journal.Files) != 1 || stats.Journal.Files[0].Path != "applied/main.tf" {
+		t.Errorf("Expected only the verified file in the journal, but got %+v", stats.Journal.Files)
+	}
+
+	// A command that cannot be started fails verification as well
+	stats = Stats{Originals: make(map[string][]byte)}
+	writeTestFiles(t, testDir, map[string]string{"other/main.tf": applied})
+	processFiles([]string{filepath.Join(testDir, "other", "main.tf")}, &stats)
+	results = verifyModules([]string{filepath.Join(binDir, "missing")}, time.Minute, nil, &stats)
+	if len(results) != 1 || results[0].Err == nil || len(results[0].RolledBack) != 1 {
+		t.Errorf("Expected a missing command to fail and roll back, but got %+v", results)
+	}
+}
+
+// TestVerifyRootModules tests that the verify command runs in the root
+// modules that call a modified module, and that a failing root rolls back
+// the modules it calls
+func TestVerifyRootModules(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the verify command is a shell script")
+	}
+
+	testDir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "verified")
+	// The prod plan changes, staging has no changes
+	script := `pwd >> "$1"
+case "$(pwd)" in
+*/live/prod) echo "Plan: 0 to add, 1 to change, 0 to destroy."; exit 2 ;;
+esac
+echo "No changes."
+`
+	app := "resource \"aws_instance\" \"web\" {}\n\nmoved {\n  from = aws_instance.old\n  to   = aws_instance.web\n}\n"
+	db := "resource \"aws_db_instance\" \"main\" {}\n\nmoved {\n  from = aws_db_instance.old\n  to   = aws_db_instance.main\n}\n"
+	writeTestFiles(t, testDir, map[string]string{
+		"verify.sh":            script,
+		"live/prod/main.tf":    "module \"app\" {\n  source = \"../../modules/app\"\n}\n",
+		"live/staging/main.tf": "module \"app\" {\n  source = \"../../modules/app\"\n}\n\nmodule \"db\" {\n  source = \"../../modules/db\"\n}\n",
+		"modules/app/main.tf":  app,
+		"modules/db/main.tf":   db,
+	})
+
+	files, err := findTerraformFiles(testDir)
+	if err != nil {
+		t.Fatalf("Failed to find Terraform files: %v", err)
+	}
+	graph, err := newReconciler(testDir, files, nil, nil)
+	if err != nil {
+		t.Fatalf("newReconciler failed: %v", err)
+	}
+	stats := Stats{Originals: make(map[string][]byte)}
+	processFiles(files, &stats)
+	results := verifyModules([]string{"sh", filepath.Join(testDir, "verify.sh"), logPath}, time.Minute, graph, &stats)
+
+	prod := filepath.Join(testDir, "live", "prod")
+	staging := filepath.Join(testDir, "live", "staging")
+	if len(results) != 2 || results[0].Dir != prod || results[1].Dir != staging {
+		t.Fatalf("Expected verification in the two root modules, but got %+v", results)
+	}
+	appFile := filepath.Join(testDir, "modules", "app", "main.tf")
+	if results[0].Err == nil || len(results[0].RolledBack) != 1 || results[0].RolledBack[0] != appFile {
+		t.Errorf("Expected prod to fail and roll back the app module, but got %+v", results[0])
+	}
+	if results[1].Err != nil || len(results[1].RolledBack) != 0 {
+		t.Errorf("Expected staging to pass, but got %+v", results[1])
+	}
+
+	verified, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read the verify log: %v", err)
+	}
+	if strings.Contains(string(verified), "modules") {
+		t.Errorf("Expected no verification in child modules, but got:\n%s", verified)
+	}
+
+	content, _ := os.ReadFile(appFile)
+	if string(content) != app {
+		t.Errorf("Expected the app module to be rolled back, but got:\n%s", content)
+	}
+	content, _ = os.ReadFile(filepath.Join(testDir, "modules", "db", "main.tf"))
+	if strings.Contains(string(content), "moved {") {
+		t.Errorf("Expected the db module, only called by staging, to keep its changes, but got:\n%s", content)
+	}
+	if stats.FilesModified != 1 || stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected 1 file and 1 block left modified, but got %d and %d", stats.FilesModified, stats.MovedBlocksRemoved)
+	}
+}
+
+// TestVerifyWithInvalidFile tests that a file that cannot be parsed fails on
+// its own instead of keeping the module call graph from being built
+func TestVerifyWithInvalidFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the verify command is the true utility")
+	}
+
+	testDir := t.TempDir()
+	writeTestFiles(t, testDir, map[string]string{
+		"live/main.tf":        "module \"app\" {\n  source = \"../modules/app\"\n}\n",
+		"live/bad.tf":         "resource \"aws_instance\" {\n",
+		"modules/app/main.tf": "moved {\n  from = aws_instance.old\n  to   = aws_instance.web\n}\n",
+	})
+
+	files, err := findTerraformFiles(testDir)
+	if err != nil {
+		t.Fatalf("Failed to find Terraform files: %v", err)
+	}
+	graph, err := newReconciler(testDir, files, nil, nil)
+	if err != nil {
+		t.Fatalf("Expected the call graph to be built despite the invalid file, but got %v", err)
+	}
+
+	stats := Stats{Originals: make(map[string][]byte)}
+	processFiles(files, &stats)
+	if len(stats.Failures) != 1 || stats.Failures[0].Path != filepath.Join(testDir, "live", "bad.tf") {
+		t.Errorf("Expected only the invalid file to fail, but got %+v", stats.Failures)
+	}
+
+	// The module is still verified in the root module that calls it
+	results := verifyModules([]string{"true"}, time.Minute, graph, &stats)
+	if len(results) != 1 || results[0].Dir != filepath.Join(testDir, "live") || results[0].Err != nil {
+		t.Errorf("Expected verification to pass in the root module, but got %+v", results)
+	}
+	if stats.FilesModified != 1 {
+		t.Errorf("Expected the module file to stay modified, but got %d files modified", stats.FilesModified)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// journalDir is the directory in the scanned tree where the journal of the
+// last run is kept
+const journalDir = ".moved-remover"
+
+// journalFile is the name of the journal inside journalDir
+const journalFile = "journal.json"
+
+// journal records the files modified by a run so that the run can be undone
+type journal struct {
+	Version int            `json:"version"`
+	Time    time.Time      `json:"time"`
+	Files   []journalEntry `json:"files"`
+
+	// root is the scanned directory that paths are relative to
+	root string
+}
+
+// journalEntry records one modified file. Path is relative to the scanned
+// directory.
+type journalEntry struct {
+	Path          string   `json:"path"`
+	OriginalHash  string   `json:"original_hash"`
+	NewHash       string   `json:"new_hash"`
+	RemovedBlocks []string `json:"removed_blocks,omitempty"`
+	Original      string   `json:"original"`
+	// Deleted is set when the run deleted the file, in which case NewHash
+	// is empty
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// journalPath returns the path of the journal for a scanned directory
+func journalPath(rootDir string) string {
+	return filepath.Join(rootDir, journalDir, journalFile)
+}
+
+// contentHash returns the hex encoded SHA-256 of content
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// newJournal creates an empty journal for a run over rootDir starting now
+func newJournal(rootDir string) *journal {
+	return &journal{Version: 1, Time: time.Now().UTC(), root: rootDir}
+}
+
+// record adds a file that is about to be overwritten, along with the text of
+// the moved blocks removed from it
+func (j *journal) record(filePath string, original, modified []byte, removed []RemovedBlock) error {
+	rel, err := filepath.Rel(j.root, filePath)
+	if err != nil {
+		return fmt.Errorf("error recording %s in the journal: %w", filePath, err)
+	}
+	entry := journalEntry{
+		Path:         filepath.ToSlash(rel),
+		OriginalHash: contentHash(original),
+		NewHash:      contentHash(modified),
+		Original:     string(original),
+	}
+	for _, block := range removed {
+		if block.Path == filePath {
+			entry.RemovedBlocks = append(entry.RemovedBlocks, block.Text)
+		}
+	}
+	j.Files = append(j.Files, entry)
+	return nil
+}
+
+// recordDeleted adds a file that is about to be deleted because nothing but
+// comments was left in it. The modified content is ignored.
+func (j *journal) recordDeleted(filePath string, original, _ []byte, removed []RemovedBlock) error {
+	if err := j.record(filePath, original, nil, removed); err != nil {
+		return err
+	}
+	entry := &j.Files[len(j.Files)-1]
+	entry.NewHash = ""
+	entry.Deleted = true
+	return nil
+}
+
+// forget removes a file from the journal, for example after it was rolled
+// back to its original content
+func (j *journal) forget(filePath string) {
+	rel, err := filepath.Rel(j.root, filePath)
+	if err != nil {
+		return
+	}
+	files := j.Files[:0]
+	for _, entry := range j.Files {
+		if entry.Path != filepath.ToSlash(rel) {
+			files = append(files, entry)
+		}
+	}
+	j.Files = files
+}
+
+// write saves the journal in the scanned directory, replacing the journal of
+// the previous run. A run that modified nothing keeps the previous journal,
+// so that the last run that did can still be undone.
+func (j *journal) write() error {
+	if len(j.Files) == 0 {
+		return nil
+	}
+	path := journalPath(j.root)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	if err := os.WriteFile(path, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing journal: %w", err)
+	}
+	return nil
+}
+
+// readJournal reads the journal of the last run in a scanned directory
+func readJournal(rootDir string) (*journal, error) {
+	content, err := os.ReadFile(journalPath(rootDir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading journal: %w", err)
+	}
+	var j journal
+	if err := json.Unmarshal(content, &j); err != nil {
+		return nil, fmt.Errorf("error parsing journal %s: %w", journalPath(rootDir), err)
+	}
+	if j.Version != 1 {
+		return nil, fmt.Errorf("journal %s has unsupported version %d", journalPath(rootDir), j.Version)
+	}
+	j.root = rootDir
+	return &j, nil
+}
+
+// undoResult describes what undo did with one journal entry
+type undoResult struct {
+	Path string
+	// Reverted is false when the file was left alone, and Reason says why
+	Reverted bool
+	Reason   string
+}
+
+// undoAlreadyOriginal is the reason given for files that were reverted
+// already
+const undoAlreadyOriginal = "already has its original content"
+
+// undoJournal reverts the files recorded in a journal. Files whose content
+// no longer matches what the run wrote were edited since, and are skipped.
+func undoJournal(j *journal, dryRun bool) ([]undoResult, error) {
+	var results []undoResult
+	for _, entry := range j.Files {
+		path := filepath.Join(j.root, filepath.FromSlash(entry.Path))
+		result := undoResult{Path: path}
+
+		current, err := os.ReadFile(path)
+		switch {
+		case entry.Deleted && os.IsNotExist(err):
+			// The file was deleted by the run and is restored below
+		case err != nil:
+			result.Reason = "cannot be read"
+		case contentHash(current) == entry.OriginalHash:
+			result.Reason = undoAlreadyOriginal
+		case entry.Deleted:
+			result.Reason = "was created again after the run"
+		case contentHash(current) != entry.NewHash:
+			result.Reason = "was changed after the run"
+		}
+		if result.Reason == "" {
+			if contentHash([]byte(entry.Original)) != entry.OriginalHash {
+				result.Reason = "has a corrupt journal entry"
+			} else {
+				result.Reverted = true
+			}
+		}
+
+		if result.Reverted && !dryRun {
+			mode := os.FileMode(0644)
+			if info, err := os.Stat(path); err == nil {
+				mode = info.Mode().Perm()
+			}
+			if err := os.WriteFile(path, []byte(entry.Original), mode); err != nil {
+				return results, fmt.Errorf("error writing file %s: %w", path, err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// runUndo implements the undo subcommand, which reverts the last run
+func runUndo(args []string) int {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	dryRunFlag := fs.Bool("dry-run", false, "Show which files would be reverted without writing them")
+	fs.Usage = func() {
+		fmt.Println("Usage: terraform-moved-remover undo [options] [directory]")
+		fmt.Println("       Reverts the files modified by the last run in directory, using")
+		fmt.Printf("       %s/%s. Files edited since the run are left alone.\n", journalDir, journalFile)
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return 1
+	}
+	rootDir := "."
+	if fs.NArg() == 1 {
+		rootDir = fs.Arg(0)
+	}
+
+	j, err := readJournal(rootDir)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	results, err := undoJournal(j, *dryRunFlag)
+	reverted, skipped := 0, 0
+	for _, result := range results {
+		switch {
+		case result.Reverted:
+			reverted++
+			fmt.Printf("Reverted: %s\n", result.Path)
+		case result.Reason == undoAlreadyOriginal:
+			fmt.Printf("Unchanged: %s (%s)\n", result.Path, result.Reason)
+		default:
+			skipped++
+			fmt.Printf("Skipped: %s (%s)\n", result.Path, result.Reason)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+
+	if *dryRunFlag {
+		fmt.Println("DRY RUN MODE: No files were modified")
+	}
+	fmt.Printf("Files reverted: %d\n", reverted)
+	fmt.Printf("Files skipped: %d\n", skipped)
+
+	// Keep the journal while some files could not be reverted, so that undo
+	// can be run again once they are fixed by hand
+	if !*dryRunFlag && skipped == 0 {
+		if err := os.Remove(journalPath(rootDir)); err != nil {
+			fmt.Printf("Error removing journal: %s\n", err)
+			return 1
+		}
+	}
+	return 0
+}
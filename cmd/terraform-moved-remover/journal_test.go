@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestJournalUndo tests that undo reverts files written by the last run but
+// leaves files edited since alone
+func TestJournalUndo(t *testing.T) {
+	rootDir := t.TempDir()
+	original := `resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+	writeTestFiles(t, rootDir, map[string]string{
+		"a/main.tf": original,
+		"b/main.tf": original,
+		"c/main.tf": "resource \"aws_vpc\" \"main\" {}\n",
+	})
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	stats := Stats{Journal: newJournal(rootDir)}
+	processFiles(files, &stats)
+	if err := stats.Journal.write(); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	j, err := readJournal(rootDir)
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	if len(j.Files) != 2 {
+		t.Fatalf("Expected 2 files in the journal, but got %d", len(j.Files))
+	}
+	entry := j.Files[0]
+	if entry.Path != "a/main.tf" {
+		t.Errorf("Expected path a/main.tf, but got %s", entry.Path)
+	}
+	if entry.OriginalHash != contentHash([]byte(original)) {
+		t.Errorf("Expected the original hash to match the original content")
+	}
+	if len(entry.RemovedBlocks) != 1 || !strings.Contains(entry.RemovedBlocks[0], "from = aws_instance.old") {
+		t.Errorf("Expected the removed block text, but got %q", entry.RemovedBlocks)
+	}
+
+	// Edit one of the files by hand after the run
+	edited := filepath.Join(rootDir, "b", "main.tf")
+	if err := os.WriteFile(edited, []byte("resource \"aws_instance\" \"edited\" {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to edit file: %v", err)
+	}
+
+	results, err := undoJournal(j, false)
+	if err != nil {
+		t.Fatalf("undoJournal failed: %v", err)
+	}
+	if len(results) != 2 || !results[0].Reverted || results[1].Reverted {
+		t.Fatalf("Expected only a/main.tf to be reverted, but got %+v", results)
+	}
+	if results[1].Reason != "was changed after the run" {
+		t.Errorf("Expected the edited file to be reported as changed, but got %q", results[1].Reason)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(rootDir, "a", "main.tf"))
+	if string(content) != original {
+		t.Errorf("Expected a/main.tf to be reverted, but got:\n%s", content)
+	}
+	content, _ = os.ReadFile(edited)
+	if !strings.Contains(string(content), "edited") {
+		t.Errorf("Expected the hand edit to be kept, but got:\n%s", content)
+	}
+
+	// Undoing again finds the reverted file already in its original state
+	results, err = undoJournal(j, false)
+	if err != nil {
+		t.Fatalf("undoJournal failed: %v", err)
+	}
+	if results[0].Reverted || results[0].Reason != undoAlreadyOriginal {
+		t.Errorf("Expected a/main.tf to be reported as original, but got %+v", results[0])
+	}
+}
+
+// TestJournalNoopRun tests that a run that modifies nothing keeps the journal
+// of the last run that did, so that run can still be undone
+func TestJournalNoopRun(t *testing.T) {
+	rootDir := t.TempDir()
+	original := "resource \"aws_instance\" \"web\" {}\n\nmoved {\n  from = aws_instance.old\n  to   = aws_instance.web\n}\n"
+	writeTestFiles(t, rootDir, map[string]string{"main.tf": original})
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	for run := 1; run <= 2; run++ {
+		stats := Stats{Journal: newJournal(rootDir)}
+		processFiles(files, &stats)
+		if stats.FilesModified != 2-run {
+			t.Fatalf("Run %d: expected %d files modified, but got %d", run, 2-run, stats.FilesModified)
+		}
+		if err := stats.Journal.write(); err != nil {
+			t.Fatalf("Run %d: write failed: %v", run, err)
+		}
+	}
+
+	j, err := readJournal(rootDir)
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	results, err := undoJournal(j, false)
+	if err != nil {
+		t.Fatalf("undoJournal failed: %v", err)
+	}
+	if len(results) != 1 || !results[0].Reverted {
+		t.Fatalf("Expected the first run to be undone, but got %+v", results)
+	}
+	content, _ := os.ReadFile(filepath.Join(rootDir, "main.tf"))
+	if string(content) != original {
+		t.Errorf("Expected main.tf to be reverted, but got:\n%s", content)
+	}
+}
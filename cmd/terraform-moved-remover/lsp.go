@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// LSP diagnostic severities
+const (
+	lspSeverityError       = 1
+	lspSeverityWarning     = 2
+	lspSeverityInformation = 3
+	lspSeverityHint        = 4
+)
+
+// JSON-RPC error codes
+const (
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+)
+
+// lspDiagnosticSource is reported as the source of every published diagnostic
+const lspDiagnosticSource = "terraform-moved-remover"
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspWorkspaceEdit struct {
+	Changes map[string][]lspTextEdit `json:"changes"`
+}
+
+type lspCodeAction struct {
+	Title       string           `json:"title"`
+	Kind        string           `json:"kind"`
+	Diagnostics []lspDiagnostic  `json:"diagnostics,omitempty"`
+	Edit        lspWorkspaceEdit `json:"edit"`
+}
+
+type lspTextDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspTextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type lspDidOpenParams struct {
+	TextDocument lspTextDocumentItem `json:"textDocument"`
+}
+
+type lspDidChangeParams struct {
+	TextDocument   lspTextDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type lspDidCloseParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+}
+
+type lspCodeActionParams struct {
+	TextDocument lspTextDocumentIdentifier `json:"textDocument"`
+	Range        lspRange                  `json:"range"`
+}
+
+type lspPublishDiagnosticsParams struct {
+	URI         string          `json:"uri"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+// lspServer is a minimal Language Server Protocol server that reports moved
+// blocks as diagnostics and offers code actions to remove them
+type lspServer struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	writeMu sync.Mutex
+	docs    map[string][]byte
+	stats   Stats
+}
+
+// newLSPServer creates a server reading requests from r and writing
+// responses and notifications to w
+func newLSPServer(r io.Reader, w io.Writer, stats Stats) *lspServer {
+	return &lspServer{
+		in:    bufio.NewReader(r),
+		out:   w,
+		docs:  make(map[string][]byte),
+		stats: stats,
+	}
+}
+
+// runLSP implements the lsp subcommand, serving over stdin and stdout
+func runLSP(args []string) int {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	normalizeFlag := fs.Bool("normalize-whitespace", false, "Normalize whitespace after removing moved blocks")
+	dirFlag := fs.String("dir", ".", "Directory the module call graph is built from, which -state and -plan-json roots are relative to")
+	stateFiles := stateFlag{}
+	fs.Var(stateFiles, "state", "State file for a root module as [root=]path (repeatable)")
+	planFiles := stateFlag{}
+	fs.Var(planFiles, "plan-json", "terraform show -json output of a plan for a root module as [root=]path (repeatable)")
+	configFlag := fs.String("config", "", "Configuration file with keep and remove rules (default "+configFile+" in -dir, if it exists)")
+	_ = fs.Parse(args)
+
+	stats, err := newLSPStats(*dirFlag, stateFiles, planFiles, *configFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	stats.NormalizeWhitespace = *normalizeFlag
+
+	server := newLSPServer(os.Stdin, os.Stdout, stats)
+	if err := server.serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// newLSPStats prepares what diagnostics are derived from: a reconciler for
+// the state files and plans, if any are given, and the rules of the
+// configuration file
+func newLSPStats(dir string, stateFiles, planFiles map[string][]string, configPath string) (Stats, error) {
+	var stats Stats
+	if len(stateFiles) > 0 || len(planFiles) > 0 {
+		files, err := findTerraformFiles(dir)
+		if err != nil {
+			return stats, err
+		}
+		stats.Reconciler, err = newReconciler(dir, files, stateFiles, planFiles)
+		if err != nil {
+			return stats, fmt.Errorf("error building module call graph: %w", err)
+		}
+	}
+
+	if configPath == "" {
+		if _, err := os.Stat(filepath.Join(dir, configFile)); err == nil {
+			configPath = filepath.Join(dir, configFile)
+		}
+	}
+	if configPath != "" {
+		rules, err := loadPolicy(configPath, dir)
+		if err != nil {
+			return stats, err
+		}
+		stats.Deciders = append(stats.Deciders, rules)
+	}
+	return stats, nil
+}
+
+// serve handles messages until the client sends exit or closes the stream
+func (s *lspServer) serve() error {
+	for {
+		msg, err := s.readMessage()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads a single message framed with a Content-Length header
+func (s *lspServer) readMessage() (*lspMessage, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid Content-Length: %w", err)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("invalid message: %w", err)
+	}
+	return &msg, nil
+}
+
+// writeMessage frames and writes a single message
+func (s *lspServer) writeMessage(msg *lspMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(body)
+	return err
+}
+
+func (s *lspServer) reply(id *json.RawMessage, result any) error {
+	if result == nil {
+		result = json.RawMessage("null")
+	}
+	return s.writeMessage(&lspMessage{ID: id, Result: result})
+}
+
+func (s *lspServer) replyError(id *json.RawMessage, code int, message string) error {
+	return s.writeMessage(&lspMessage{ID: id, Error: &lspError{Code: code, Message: message}})
+}
+
+func (s *lspServer) notify(method string, params any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return s.writeMessage(&lspMessage{Method: method, Params: raw})
+}
+
+// handle dispatches a single request or notification
+func (s *lspServer) handle(msg *lspMessage) error {
+	switch msg.Method {
+	case "initialize":
+		return s.reply(msg.ID, map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // full document sync
+				"codeActionProvider": true,
+			},
+			"serverInfo": map[string]string{
+				"name":    "terraform-moved-remover",
+				"version": Version,
+			},
+		})
+	case "shutdown":
+		return s.reply(msg.ID, nil)
+	case "textDocument/didOpen":
+		var params lspDidOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		s.docs[params.TextDocument.URI] = []byte(params.TextDocument.Text)
+		return s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params lspDidChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		last := params.ContentChanges[len(params.ContentChanges)-1]
+		s.docs[params.TextDocument.URI] = []byte(last.Text)
+		return s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didClose":
+		var params lspDidCloseParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", lspPublishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []lspDiagnostic{},
+		})
+	case "textDocument/codeAction":
+		var params lspCodeActionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return s.replyError(msg.ID, jsonrpcInvalidParams, err.Error())
+		}
+		return s.reply(msg.ID, s.codeActions(params))
+	}
+
+	// Unknown notifications are ignored, unknown requests get an error
+	if msg.ID != nil {
+		return s.replyError(msg.ID, jsonrpcMethodNotFound, "method not found: "+msg.Method)
+	}
+	return nil
+}
+
+// publishDiagnostics reports every moved block in the document
+func (s *lspServer) publishDiagnostics(uri string) error {
+	diagnostics := []lspDiagnostic{}
+
+	_, moved, err := remover.Parse(uriFilename(uri), s.docs[uri])
+	var diagErr *remover.DiagnosticsError
+	if errors.As(err, &diagErr) {
+		for _, diag := range diagErr.Diagnostics {
+			if diag.Subject == nil {
+				continue
+			}
+			diagnostics = append(diagnostics, lspDiagnostic{
+				Range:    toLSPRange(*diag.Subject),
+				Severity: lspSeverityError,
+				Source:   lspDiagnosticSource,
+				Message:  diag.Summary + ": " + diag.Detail,
+			})
+		}
+	}
+
+	chained := chainedBlocks(moved)
+	for _, mb := range moved {
+		diagnostics = append(diagnostics, s.movedBlockDiagnostic(uri, mb, chained[mb]))
+	}
+
+	return s.notify("textDocument/publishDiagnostics", lspPublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnostics,
+	})
+}
+
+// movedBlockDiagnostic describes a moved block for the editor. The block is
+// decided as a run would decide it, so the message says why it is still
+// needed or what shows that it can be removed. Without state files, plans or
+// rules, nothing is known about the move and the diagnostic is only a hint.
+func (s *lspServer) movedBlockDiagnostic(uri string, mb *remover.MovedBlock, chained bool) lspDiagnostic {
+	filePath := uriFilename(uri)
+	kept, err := s.stats.removerOptions(filePath).Decide(filePath, mb)
+
+	var message string
+	severity := lspSeverityHint
+	switch {
+	case err != nil:
+		message = err.Error()
+		severity = lspSeverityError
+	case kept != nil:
+		message = fmt.Sprintf("moved block from %s to %s is still needed: %s", mb.From, mb.To, kept.Reason)
+	default:
+		var evidence []string
+		if s.stats.Reconciler != nil {
+			evidence = append(evidence, "already applied per "+s.stats.Reconciler.evidence())
+		}
+		if mb.Reason != "" {
+			evidence = append(evidence, mb.Reason)
+		}
+		if len(evidence) > 0 {
+			message = fmt.Sprintf("moved block from %s to %s can be removed: %s", mb.From, mb.To, strings.Join(evidence, "; "))
+			severity = lspSeverityWarning
+		} else {
+			message = fmt.Sprintf("moved block from %s to %s can be removed once the move has been applied", mb.From, mb.To)
+		}
+	}
+
+	if chained {
+		message += "; it is part of a chain of moves that can be collapsed"
+		if severity == lspSeverityHint {
+			severity = lspSeverityInformation
+		}
+	}
+	return lspDiagnostic{
+		Range:    toLSPRange(mb.Range),
+		Severity: severity,
+		Source:   lspDiagnosticSource,
+		Message:  message,
+	}
+}
+
+// codeActions returns the actions available for moved blocks overlapping the
+// requested range
+func (s *lspServer) codeActions(params lspCodeActionParams) []lspCodeAction {
+	actions := []lspCodeAction{}
+
+	uri := params.TextDocument.URI
+	content, ok := s.docs[uri]
+	if !ok {
+		return actions
+	}
+
+	_, moved, err := remover.Parse(uriFilename(uri), content)
+	if err != nil || len(moved) == 0 {
+		return actions
+	}
+
+	chained := chainedBlocks(moved)
+	for i, mb := range moved {
+		if !rangesOverlap(toLSPRange(mb.Range), params.Range) {
+			continue
+		}
+
+		if newText, err := s.rewrite(uri, content, func(blocks []*remover.MovedBlock) []*remover.MovedBlock {
+			return blocks[i : i+1]
+		}); err == nil {
+			actions = append(actions, lspCodeAction{
+				Title:       "Remove this moved block",
+				Kind:        "quickfix",
+				Diagnostics: []lspDiagnostic{s.movedBlockDiagnostic(uri, mb, chained[mb])},
+				Edit:        replaceDocument(uri, content, newText),
+			})
+		}
+
+		if chained[mb] {
+			if newText, err := s.collapseChain(uri, content, i); err == nil {
+				actions = append(actions, lspCodeAction{
+					Title: "Collapse chain",
+					Kind:  "refactor.rewrite",
+					Edit:  replaceDocument(uri, content, newText),
+				})
+			}
+		}
+	}
+
+	if newText, err := s.rewrite(uri, content, func(blocks []*remover.MovedBlock) []*remover.MovedBlock {
+		return blocks
+	}); err == nil {
+		actions = append(actions, lspCodeAction{
+			Title: "Remove all moved blocks in file",
+			Kind:  "source.fixAll",
+			Edit:  replaceDocument(uri, content, newText),
+		})
+	}
+
+	return actions
+}
+
+// rewrite removes the moved blocks chosen by selectBlocks from a fresh parse
+// of the document and returns the formatted result
+func (s *lspServer) rewrite(uri string, content []byte, selectBlocks func([]*remover.MovedBlock) []*remover.MovedBlock) (string, error) {
+	format := remover.DetectTextFormat(content)
+	file, moved, err := remover.Parse(uriFilename(uri), format.Normalize(content))
+	if err != nil {
+		return "", err
+	}
+
+	removed := selectBlocks(moved)
+	blocks := make([]*hclwrite.Block, 0, len(removed))
+	for _, mb := range removed {
+		blocks = append(blocks, mb.Block)
+	}
+	return string(format.Apply(remover.Format(remover.RemoveBlocks(file, blocks), s.stats.NormalizeWhitespace && len(blocks) > 0))), nil
+}
+
+// collapseChain rewrites the chain containing the i-th moved block so that
+// the first address moves directly to the final one. Intermediate blocks of
+// the chain are removed.
+func (s *lspServer) collapseChain(uri string, content []byte, i int) (string, error) {
+	format := remover.DetectTextFormat(content)
+	file, moved, err := remover.Parse(uriFilename(uri), format.Normalize(content))
+	if err != nil {
+		return "", err
+	}
+
+	chain := moveChain(moved, moved[i])
+	if len(chain) < 2 {
+		return "", errors.New("moved block is not part of a chain")
+	}
+
+	head := chain[0]
+	tail := chain[len(chain)-1]
+	head.Block.Body().SetAttributeRaw("to", tail.Block.Body().GetAttribute("to").Expr().BuildTokens(nil))
+	blocks := make([]*hclwrite.Block, 0, len(chain)-1)
+	for _, mb := range chain[1:] {
+		blocks = append(blocks, mb.Block)
+	}
+	return string(format.Apply(remover.Format(remover.RemoveBlocks(file, blocks), s.stats.NormalizeWhitespace && len(blocks) > 0))), nil
+}
+
+// chainedBlocks reports which moved blocks take part in a chain, where the
+// "to" address of one block is the "from" address of another
+func chainedBlocks(moved []*remover.MovedBlock) map[*remover.MovedBlock]bool {
+	chained := make(map[*remover.MovedBlock]bool)
+	for _, a := range moved {
+		for _, b := range moved {
+			if a != b && a.To != "" && remover.NormalizeAddress(a.To) == remover.NormalizeAddress(b.From) {
+				chained[a] = true
+				chained[b] = true
+			}
+		}
+	}
+	return chained
+}
+
+// moveChain returns the chain of moves that contains mb, ordered from the
+// oldest address to the newest
+func moveChain(moved []*remover.MovedBlock, mb *remover.MovedBlock) []*remover.MovedBlock {
+	byFrom := make(map[string]*remover.MovedBlock)
+	byTo := make(map[string]*remover.MovedBlock)
+	for _, b := range moved {
+		byFrom[remover.NormalizeAddress(b.From)] = b
+		byTo[remover.NormalizeAddress(b.To)] = b
+	}
+
+	// Walk back to the start of the chain, guarding against cycles
+	head := mb
+	seen := map[*remover.MovedBlock]bool{head: true}
+	for prev := byTo[remover.NormalizeAddress(head.From)]; prev != nil && !seen[prev]; prev = byTo[remover.NormalizeAddress(head.From)] {
+		head = prev
+		seen[head] = true
+	}
+
+	chain := []*remover.MovedBlock{head}
+	seen = map[*remover.MovedBlock]bool{head: true}
+	for next := byFrom[remover.NormalizeAddress(head.To)]; next != nil && !seen[next]; next = byFrom[remover.NormalizeAddress(next.To)] {
+		chain = append(chain, next)
+		seen[next] = true
+	}
+	return chain
+}
+
+// replaceDocument builds an edit replacing the whole document
+func replaceDocument(uri string, content []byte, newText string) lspWorkspaceEdit {
+	lines := strings.Count(string(content), "\n")
+	return lspWorkspaceEdit{
+		Changes: map[string][]lspTextEdit{
+			uri: {{
+				Range: lspRange{
+					Start: lspPosition{Line: 0, Character: 0},
+					End:   lspPosition{Line: lines + 1, Character: 0},
+				},
+				NewText: newText,
+			}},
+		},
+	}
+}
+
+// toLSPRange converts an HCL range to a zero-based LSP range
+func toLSPRange(r hcl.Range) lspRange {
+	return lspRange{
+		Start: lspPosition{Line: max(r.Start.Line-1, 0), Character: max(r.Start.Column-1, 0)},
+		End:   lspPosition{Line: max(r.End.Line-1, 0), Character: max(r.End.Column-1, 0)},
+	}
+}
+
+// rangesOverlap reports whether two LSP ranges share at least one position
+func rangesOverlap(a, b lspRange) bool {
+	return !positionBefore(a.End, b.Start) && !positionBefore(b.End, a.Start)
+}
+
+func positionBefore(a, b lspPosition) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
+
+// uriFilename converts a file URI into a path used in diagnostics
+func uriFilename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
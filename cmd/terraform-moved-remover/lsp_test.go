@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspTestClient drives an in-process lspServer over pipes
+type lspTestClient struct {
+	t      *testing.T
+	w      io.WriteCloser
+	r      *bufio.Reader
+	nextID int
+	done   chan error
+}
+
+func newLSPTestClient(t *testing.T, stats Stats) *lspTestClient {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	server := newLSPServer(serverR, serverW, stats)
+	done := make(chan error, 1)
+	go func() {
+		err := server.serve()
+		serverW.Close()
+		done <- err
+	}()
+
+	return &lspTestClient{t: t, w: clientW, r: bufio.NewReader(clientR), done: done}
+}
+
+func (c *lspTestClient) send(msg map[string]any) {
+	msg["jsonrpc"] = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatalf("Failed to marshal message: %v", err)
+	}
+	if _, err := fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		c.t.Fatalf("Failed to write message: %v", err)
+	}
+}
+
+func (c *lspTestClient) request(method string, params any) json.RawMessage {
+	c.nextID++
+	c.send(map[string]any{"id": c.nextID, "method": method, "params": params})
+	for {
+		msg := c.read()
+		if msg.ID != nil && string(*msg.ID) == strconv.Itoa(c.nextID) {
+			if msg.Error != nil {
+				c.t.Fatalf("Request %s failed: %s", method, msg.Error.Message)
+			}
+			raw, _ := json.Marshal(msg.Result)
+			return raw
+		}
+	}
+}
+
+func (c *lspTestClient) read() *lspMessage {
+	length := 0
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("Failed to read header: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v, ok := strings.CutPrefix(line, "Content-Length: "); ok {
+			length, _ = strconv.Atoi(v)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatalf("Failed to read body: %v", err)
+	}
+	var msg lspMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("Failed to decode message: %v", err)
+	}
+	return &msg
+}
+
+func (c *lspTestClient) diagnostics() lspPublishDiagnosticsParams {
+	for {
+		msg := c.read()
+		if msg.Method == "textDocument/publishDiagnostics" {
+			var params lspPublishDiagnosticsParams
+			if err := json.Unmarshal(msg.Params, &params); err != nil {
+				c.t.Fatalf("Failed to decode diagnostics: %v", err)
+			}
+			return params
+		}
+	}
+}
+
+func (c *lspTestClient) close() {
+	c.request("shutdown", nil)
+	c.send(map[string]any{"method": "exit"})
+	if err := <-c.done; err != nil {
+		c.t.Errorf("Server returned error: %v", err)
+	}
+	c.w.Close()
+}
+
+// TestLSPServer tests diagnostics and code actions over the protocol
+func TestLSPServer(t *testing.T) {
+	client := newLSPTestClient(t, Stats{NormalizeWhitespace: true})
+	defer client.close()
+
+	client.request("initialize", map[string]any{"capabilities": map[string]any{}})
+	client.send(map[string]any{"method": "initialized", "params": map[string]any{}})
+
+	uri := "file:///project/main.tf"
+	text := `resource "aws_instance" "web" {
+  ami = "ami-123456"
+}
+
+moved {
+  from = aws_instance.a
+  to   = aws_instance.b
+}
+
+moved {
+  from = aws_instance.b
+  to   = aws_instance.web
+}
+
+moved {
+  from = aws_s3_bucket.old
+  to   = aws_s3_bucket.new
+}
+`
+	client.send(map[string]any{
+		"method": "textDocument/didOpen",
+		"params": map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": "terraform", "version": 1, "text": text},
+		},
+	})
+
+	diags := client.diagnostics()
+	if diags.URI != uri {
+		t.Errorf("Expected diagnostics for %s, but got %s", uri, diags.URI)
+	}
+	if len(diags.Diagnostics) != 3 {
+		t.Fatalf("Expected 3 diagnostics, but got %d", len(diags.Diagnostics))
+	}
+	if diags.Diagnostics[0].Range.Start.Line != 4 {
+		t.Errorf("Expected first diagnostic on line 4, but got %d", diags.Diagnostics[0].Range.Start.Line)
+	}
+	if !strings.Contains(diags.Diagnostics[0].Message, "chain") {
+		t.Errorf("Expected first diagnostic to mention the chain, but got %q", diags.Diagnostics[0].Message)
+	}
+	if strings.Contains(diags.Diagnostics[2].Message, "chain") {
+		t.Errorf("Expected last diagnostic not to mention a chain, but got %q", diags.Diagnostics[2].Message)
+	}
+
+	raw := client.request("textDocument/codeAction", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"range":        lspRange{Start: lspPosition{Line: 5, Character: 2}, End: lspPosition{Line: 5, Character: 2}},
+		"context":      map[string]any{"diagnostics": []any{}},
+	})
+	var actions []lspCodeAction
+	if err := json.Unmarshal(raw, &actions); err != nil {
+		t.Fatalf("Failed to decode code actions: %v", err)
+	}
+
+	byTitle := make(map[string]lspCodeAction)
+	for _, action := range actions {
+		byTitle[action.Title] = action
+	}
+
+	removeOne, ok := byTitle["Remove this moved block"]
+	if !ok {
+		t.Fatalf("Expected a remove action, got %v", actions)
+	}
+	newText := removeOne.Edit.Changes[uri][0].NewText
+	if strings.Contains(newText, "aws_instance.a") || !strings.Contains(newText, "aws_s3_bucket.old") {
+		t.Errorf("Unexpected result of removing one block:\n%s", newText)
+	}
+
+	removeAll, ok := byTitle["Remove all moved blocks in file"]
+	if !ok {
+		t.Fatalf("Expected a remove all action, got %v", actions)
+	}
+	expected := `resource "aws_instance" "web" {
+  ami = "ami-123456"
+}
+`
+	if got := removeAll.Edit.Changes[uri][0].NewText; got != expected {
+		t.Errorf("Expected remove all result:\n%s\nActual:\n%s", expected, got)
+	}
+
+	collapse, ok := byTitle["Collapse chain"]
+	if !ok {
+		t.Fatalf("Expected a collapse action, got %v", actions)
+	}
+	collapsed := collapse.Edit.Changes[uri][0].NewText
+	if !strings.Contains(collapsed, "from = aws_instance.a\n  to   = aws_instance.web") {
+		t.Errorf("Expected chain to be collapsed, got:\n%s", collapsed)
+	}
+	if strings.Contains(collapsed, "aws_instance.b") {
+		t.Errorf("Expected intermediate address to be removed, got:\n%s", collapsed)
+	}
+
+	// Editing the document republishes diagnostics
+	client.send(map[string]any{
+		"method": "textDocument/didChange",
+		"params": map[string]any{
+			"textDocument":   map[string]any{"uri": uri, "version": 2},
+			"contentChanges": []map[string]any{{"text": expected}},
+		},
+	})
+	if diags := client.diagnostics(); len(diags.Diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics after edit, but got %d", len(diags.Diagnostics))
+	}
+}
+
+// TestLSPDiagnosticEvidence tests that diagnostics say whether a state file
+// or a rule shows that a moved block can be removed or is still needed
+func TestLSPDiagnosticEvidence(t *testing.T) {
+	rootDir := t.TempDir()
+	text := `resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+
+moved {
+  from = aws_s3_bucket.legacy
+  to   = aws_s3_bucket.data
+}
+
+moved {
+  from = aws_iam_role.a
+  to   = aws_iam_role.b
+}
+`
+	writeTestFiles(t, rootDir, map[string]string{
+		"live/main.tf": text,
+		configFile:     "keep \"roles\" {\n  when = startswith(from, \"aws_iam_role\")\n}\n",
+		"prod.tfstate": `{
+  "version": 4,
+  "resources": [
+    {"mode": "managed", "type": "aws_instance", "name": "web", "instances": [{}]},
+    {"mode": "managed", "type": "aws_s3_bucket", "name": "legacy", "instances": [{}]}
+  ]
+}`,
+	})
+
+	stats, err := newLSPStats(rootDir, map[string][]string{"live": {filepath.Join(rootDir, "prod.tfstate")}}, nil, "")
+	if err != nil {
+		t.Fatalf("newLSPStats failed: %v", err)
+	}
+	client := newLSPTestClient(t, stats)
+	defer client.close()
+
+	client.request("initialize", map[string]any{"capabilities": map[string]any{}})
+	uri := "file://" + filepath.ToSlash(filepath.Join(rootDir, "live", "main.tf"))
+	client.send(map[string]any{
+		"method": "textDocument/didOpen",
+		"params": map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": "terraform", "version": 1, "text": text},
+		},
+	})
+
+	diags := client.diagnostics().Diagnostics
+	if len(diags) != 3 {
+		t.Fatalf("Expected 3 diagnostics, but got %+v", diags)
+	}
+	expected := []struct {
+		message  string
+		severity int
+	}{
+		{"moved block from aws_instance.old to aws_instance.web can be removed: already applied per state file", lspSeverityWarning},
+		{"moved block from aws_s3_bucket.legacy to aws_s3_bucket.data is still needed: still needed by live", lspSeverityHint},
+		{`moved block from aws_iam_role.a to aws_iam_role.b is still needed: keep rule "roles" matched`, lspSeverityHint},
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(diags[i].Message, e.message) || diags[i].Severity != e.severity {
+			t.Errorf("Expected diagnostic %d to be %q with severity %d, but got %q with severity %d", i, e.message, e.severity, diags[i].Message, diags[i].Severity)
+		}
+	}
+}
@@ -2,19 +2,37 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mkusaka/terraform-moved-remover/decision"
+	"github.com/mkusaka/terraform-moved-remover/remover"
 )
 
 const Version = "0.0.6"
 
+// subcommands maps subcommand names to their entry points. Each returns the
+// process exit status.
+var subcommands = map[string]func(args []string) int{
+	"lsp":           runLSP,
+	"generate":      runGenerate,
+	"from-state-mv": runFromStateMv,
+	"undo":          runUndo,
+	"serve":         runServe,
+	"consolidate":   runConsolidate,
+}
+
 // Stats tracks statistics about the processing
 type Stats struct {
 	FilesProcessed        int
@@ -24,70 +42,247 @@ type Stats struct {
 	EndTime               time.Time
 	DryRun                bool
 	NormalizeWhitespace   bool
+	FailFast              bool
+	Verbose               bool
+	DeleteEmpty           bool
+	Failures              []FileFailure
+	Files                 []FileStats
+	Reconciler            *reconciler
+	KeptBlocks            []KeptBlock
+	RemovedBlocks         []RemovedBlock
+	Diagnostics           hcl.Diagnostics
+	Sources               map[string]*hcl.File
+	Backup                *backupConfig
+	Journal               *journal
+	// Originals holds the pre-run content of written files when it is
+	// needed to roll them back after verification
+	Originals             map[string][]byte
+	// DeletedFiles lists the files deleted because nothing but comments was
+	// left in them after their moved blocks were removed
+	DeletedFiles          []string
+	// Deciders are asked about every moved block that would be removed, and
+	// can keep it
+	Deciders              []decision.Decider
+	Cache                 *runCache
+	// CacheHits counts the files skipped because the cache shows they have
+	// no moved blocks and are already formatted
+	CacheHits             int
+}
+
+// KeptBlock records a moved block that was not removed
+type KeptBlock struct {
+	Path   string
+	From   string
+	To     string
+	Reason string
+	// Plan is set when a plan shows that the move is still pending
+	Plan bool
+}
+
+// RemovedBlock records a moved block that was removed, with its original text
+type RemovedBlock struct {
+	Path string
+	From string
+	To   string
+	Text string
+	// Reason is set when a decider, such as a rule, gave a reason for the
+	// removal
+	Reason string
+}
+
+// FileFailure records a file that could not be processed
+type FileFailure struct {
+	Path string
+	Err  error
 }
 
 // findTerraformFiles recursively finds all .tf files in the given directory
 func findTerraformFiles(rootDir string) ([]string, error) {
+	files, _, err := discoverFiles(rootDir, discoveryOptions{})
+	return files, err
+}
+
+// discoveryOptions controls which files discoverFiles returns
+type discoveryOptions struct {
+	// Terragrunt also discovers terragrunt.hcl files, the configuration files
+	// they include or read and the local module sources they reference
+	Terragrunt bool
+	// IncludeTerragruntCache descends into .terragrunt-cache directories,
+	// which are skipped by default
+	IncludeTerragruntCache bool
+	// ExcludeDirs are never scanned, such as a backup directory inside the
+	// scanned tree
+	ExcludeDirs []string
+}
+
+// includes reports whether a file is processed under these options
+func (opts discoveryOptions) includes(path string) bool {
+	return strings.HasSuffix(path, ".tf") || (opts.Terragrunt && filepath.Base(path) == terragruntConfigFile)
+}
+
+// discoverFiles recursively finds the files to process in the given
+// directory. It also returns the directories that were excluded.
+func discoverFiles(rootDir string, opts discoveryOptions) ([]string, []string, error) {
 	var files []string
+	var excluded []string
+
+	skip := make(map[string]bool)
+	for _, dir := range opts.ExcludeDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		skip[abs] = true
+	}
 
 	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("error accessing path %s: %w", path, err)
 		}
 
-		if !info.IsDir() && strings.HasSuffix(path, ".tf") {
+		if info.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil && skip[abs] {
+				return filepath.SkipDir
+			}
+			if info.Name() == terragruntCacheDir && !opts.IncludeTerragruntCache {
+				excluded = append(excluded, path)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if opts.includes(path) {
 			files = append(files, path)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return files, excluded, err
+	}
 
-	return files, err
+	if opts.Terragrunt {
+		files, err = addTerragruntIncludes(files)
+		if err != nil {
+			return files, excluded, err
+		}
+		files, err = addTerragruntSources(files)
+	}
+
+	return files, excluded, err
+}
+
+// removerOptions returns the options the remover package is run with for a
+// file. The reconciler is asked about each block before s.Deciders.
+func (s *Stats) removerOptions(filePath string) remover.Options {
+	opts := remover.Options{
+		NormalizeWhitespace: s.NormalizeWhitespace,
+		Terragrunt:          isTerragruntFile(filePath),
+		Deciders:            s.Deciders,
+	}
+	if s.Reconciler != nil {
+		opts.Deciders = append([]decision.Decider{s.Reconciler}, s.Deciders...)
+	}
+	return opts
+}
+
+// removeMovedBlocks removes moved blocks from the given HCL content and
+// returns the formatted result along with the number of blocks removed.
+// Blocks that must be kept, such as moves not yet applied everywhere in
+// reconcile mode, are recorded in stats.KeptBlocks instead.
+func removeMovedBlocks(filePath string, content []byte, stats *Stats) ([]byte, int, error) {
+	result, err := remover.Remove(filePath, content, stats.removerOptions(filePath))
+	if err != nil {
+		var diagErr *remover.DiagnosticsError
+		if errors.As(err, &diagErr) {
+			stats.addDiagnostics(filePath, remover.DetectTextFormat(content).Normalize(content), diagErr.Diagnostics)
+		}
+		return nil, 0, err
+	}
+
+	stats.Diagnostics = append(stats.Diagnostics, result.Warnings...)
+	for _, kept := range result.Kept {
+		stats.recordKept(filePath, kept)
+	}
+	for _, mb := range result.Removed {
+		stats.RemovedBlocks = append(stats.RemovedBlocks, RemovedBlock{
+			Path:   filePath,
+			From:   mb.From,
+			To:     mb.To,
+			Text:   mb.Text(),
+			Reason: mb.Reason,
+		})
+	}
+
+	return result.Content, len(result.Removed), nil
+}
+
+// recordKept records a moved block that was kept. The reconciler does not
+// say through the decision interface whether a plan was among its reasons,
+// so it is asked again for blocks it kept.
+func (s *Stats) recordKept(filePath string, kept remover.KeptBlock) {
+	plan := false
+	if r, ok := kept.Decider.(*reconciler); ok {
+		_, _, plan = r.keep(filePath, kept.From)
+	}
+	s.KeptBlocks = append(s.KeptBlocks, KeptBlock{Path: filePath, From: kept.From, To: kept.To, Reason: kept.Reason, Plan: plan})
 }
 
 // processFile processes a single Terraform file to remove moved blocks
-// It returns true if the file was modified, false otherwise
 func processFile(filePath string, stats *Stats) error {
+	// Record the outcome of this file; it stays an error unless we get to the end
+	fileStats := FileStats{Path: filePath, Status: FileError}
+	defer func() {
+		stats.Files = append(stats.Files, fileStats)
+	}()
+
+	// Files the cache knows to need no changes are skipped without parsing
+	var info os.FileInfo
+	if stats.Cache != nil {
+		var err error
+		if info, err = os.Stat(filePath); err != nil {
+			return fmt.Errorf("error reading file %s: %w", filePath, err)
+		}
+		if stats.Cache.fresh(filePath, info) {
+			stats.cacheHit(&fileStats, int(info.Size()))
+			return nil
+		}
+	}
+
 	// Read file content
 	content, err := os.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("error reading file %s: %w", filePath, err)
 	}
+	fileStats.BytesBefore = len(content)
 
-	// Parse HCL file
-	file, diags := hclwrite.ParseConfig(content, filePath, hcl.Pos{Line: 1, Column: 1})
-	if diags.HasErrors() {
-		return fmt.Errorf("error parsing %s: %s", filePath, diags.Error())
+	if stats.Cache != nil && stats.Cache.matches(filePath, info, content) {
+		stats.cacheHit(&fileStats, len(content))
+		return nil
 	}
 
-	// Track if file was modified
-	fileModified := false
-	movedBlocksCount := 0
+	keptBefore := len(stats.KeptBlocks)
+	formattedContent, movedBlocksCount, err := removeMovedBlocks(filePath, content, stats)
+	if err != nil {
+		return err
+	}
+	fileModified := movedBlocksCount > 0
 
-	// Find and remove moved blocks
-	body := file.Body()
-	for _, block := range body.Blocks() {
-		if block.Type() == "moved" {
-			body.RemoveBlock(block)
-			movedBlocksCount++
-			fileModified = true
-		}
+	if stats.Cache != nil && !fileModified && len(stats.KeptBlocks) == keptBefore && bytes.Equal(formattedContent, content) {
+		stats.Cache.store(filePath, info, content)
 	}
 
+	// Files left with nothing but comments are deleted when asked to. Only
+	// files that had moved blocks removed qualify, never files that were
+	// merely reformatted, and Terragrunt files are always kept.
+	deleteFile := fileModified && stats.DeleteEmpty && !isTerragruntFile(filePath) && isEmptyConfig(formattedContent)
+
 	// Update statistics
 	stats.FilesProcessed++
 	
 	// Apply formatting to all files, not just those with moved blocks
 	// Write modified content back to file only if not in dry run mode
 	if !stats.DryRun {
-		// Format the file content
-		formattedContent := hclwrite.Format(file.Bytes())
-		
-		// Fix excessive newlines that may result from removing consecutive moved blocks
-		if fileModified && stats.NormalizeWhitespace {
-			formattedContent = normalizeConsecutiveNewlines(formattedContent)
-		}
-		
 		if fileModified || !bytes.Equal(formattedContent, content) {
 			stats.FilesModified++
 			
@@ -95,9 +290,33 @@ func processFile(filePath string, stats *Stats) error {
 				stats.MovedBlocksRemoved += movedBlocksCount
 			}
 			
-			err = os.WriteFile(filePath, formattedContent, 0644)
-			if err != nil {
-				return fmt.Errorf("error writing file %s: %w", filePath, err)
+			if stats.Journal != nil {
+				record := stats.Journal.record
+				if deleteFile {
+					record = stats.Journal.recordDeleted
+				}
+				if err := record(filePath, content, formattedContent, stats.RemovedBlocks); err != nil {
+					return err
+				}
+			}
+			if stats.Originals != nil {
+				stats.Originals[filePath] = content
+			}
+			if stats.Backup != nil {
+				if err := stats.Backup.save(filePath, content); err != nil {
+					return err
+				}
+			}
+
+			if deleteFile {
+				if err := os.Remove(filePath); err != nil {
+					return fmt.Errorf("error deleting file %s: %w", filePath, err)
+				}
+			} else {
+				err = os.WriteFile(filePath, formattedContent, 0644)
+				if err != nil {
+					return fmt.Errorf("error writing file %s: %w", filePath, err)
+				}
 			}
 		}
 	} else if fileModified {
@@ -106,33 +325,164 @@ func processFile(filePath string, stats *Stats) error {
 		stats.MovedBlocksRemoved += movedBlocksCount
 	}
 
+	fileStats.BlocksRemoved = movedBlocksCount
+	fileStats.BytesAfter = len(formattedContent)
+	switch {
+	case deleteFile:
+		stats.DeletedFiles = append(stats.DeletedFiles, filePath)
+		fileStats.Status = FileDeleted
+		fileStats.BytesAfter = 0
+	case fileModified:
+		fileStats.Status = FileRemoved
+	case !bytes.Equal(formattedContent, content):
+		fileStats.Status = FileReformatted
+	default:
+		fileStats.Status = FileUnchanged
+	}
+
 	return nil
 }
 
-// in the formatted content after removing moved blocks, and also removes trailing empty lines
-func normalizeConsecutiveNewlines(content []byte) []byte {
-	contentStr := string(content)
-	
-	re := strings.NewReplacer("\n\n\n", "\n\n", "\r\n\r\n\r\n", "\r\n\r\n")
-	
-	for {
-		newContent := re.Replace(contentStr)
-		if newContent == contentStr {
+// cacheHit records a file skipped because of the cache
+func (s *Stats) cacheHit(fileStats *FileStats, size int) {
+	s.FilesProcessed++
+	s.CacheHits++
+	fileStats.Status = FileUnchanged
+	fileStats.BytesBefore = size
+	fileStats.BytesAfter = size
+}
+
+// processFiles runs processFile over every file, recording failures in stats.
+// Processing stops at the first failure when stats.FailFast is set.
+func processFiles(files []string, stats *Stats) {
+	for i, file := range files {
+		if stats.Verbose {
+			fmt.Printf("Processing: %s\n", file)
+		}
+		err := processFile(file, stats)
+		if err == nil {
+			continue
+		}
+
+		stats.Failures = append(stats.Failures, FileFailure{Path: file, Err: err})
+
+		// Parse diagnostics are rendered together in the final report
+		var diagErr *remover.DiagnosticsError
+		if !errors.As(err, &diagErr) {
+			fmt.Printf("Error processing %s: %s\n", file, err)
+		}
+
+		if stats.FailFast {
+			for _, skipped := range files[i+1:] {
+				stats.Files = append(stats.Files, FileStats{Path: skipped, Status: FileSkipped})
+			}
 			break
 		}
-		contentStr = newContent
 	}
-	
-	// First, normalize line endings to \n for processing
-	contentStr = strings.ReplaceAll(contentStr, "\r\n", "\n")
-	
-	contentStr = strings.TrimRight(contentStr, "\n") + "\n"
-	
-	if bytes.Contains(content, []byte("\r\n")) {
-		contentStr = strings.ReplaceAll(contentStr, "\n", "\r\n")
+}
+
+// closeDeciders stops the deciders that run as separate processes
+func closeDeciders(stats *Stats) {
+	for _, decider := range stats.Deciders {
+		if closer, ok := decider.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				fmt.Printf("Error: %s\n", err)
+			}
+		}
 	}
-	
-	return []byte(contentStr)
+}
+
+// printDeletedFiles prints the files deleted because they were left empty
+func printDeletedFiles(stats *Stats) {
+	if len(stats.DeletedFiles) == 0 {
+		return
+	}
+
+	fmt.Printf("\nDeleted empty files:\n")
+	for _, file := range stats.DeletedFiles {
+		fmt.Printf("  %s\n", file)
+	}
+}
+
+// printKeptBlocks prints the moved blocks that were kept and why
+func printKeptBlocks(stats *Stats) {
+	if len(stats.KeptBlocks) == 0 {
+		return
+	}
+
+	var planned []KeptBlock
+	fmt.Printf("\nKept moved blocks:\n")
+	for _, kept := range stats.KeptBlocks {
+		if kept.Plan {
+			planned = append(planned, kept)
+		}
+		fmt.Printf("  %s: %s -> %s\n", kept.Path, kept.From, kept.To)
+		fmt.Printf("    %s\n", kept.Reason)
+	}
+
+	if len(planned) > 0 {
+		fmt.Printf("\nKept because of plan evidence:\n")
+		for _, kept := range planned {
+			fmt.Printf("  %s: %s -> %s\n", kept.Path, kept.From, kept.To)
+		}
+	}
+}
+
+// printRemovalReasons prints the moved blocks a decider, such as a rule of
+// the configuration file, gave a reason to remove
+func printRemovalReasons(stats *Stats) {
+	var explained []RemovedBlock
+	for _, removed := range stats.RemovedBlocks {
+		if removed.Reason != "" {
+			explained = append(explained, removed)
+		}
+	}
+	if len(explained) == 0 {
+		return
+	}
+
+	fmt.Printf("\nRemoved by rule:\n")
+	for _, removed := range explained {
+		fmt.Printf("  %s: %s -> %s\n", removed.Path, removed.From, removed.To)
+		fmt.Printf("    %s\n", removed.Reason)
+	}
+}
+
+// printFailures prints the files that could not be processed
+func printFailures(stats *Stats) {
+	if len(stats.Failures) == 0 {
+		return
+	}
+
+	fmt.Printf("\nFailed files: %d\n", len(stats.Failures))
+	for _, failure := range stats.Failures {
+		var diagErr *remover.DiagnosticsError
+		if errors.As(failure.Err, &diagErr) {
+			fmt.Printf("  %s: parse error (see diagnostics below)\n", failure.Path)
+			continue
+		}
+		fmt.Printf("  %s: %s\n", failure.Path, failure.Err)
+	}
+	if stats.FailFast {
+		fmt.Println("Stopped at the first failure (-fail-fast)")
+	}
+}
+
+// isEmptyConfig reports whether content has nothing left but comments and
+// blank lines
+func isEmptyConfig(content []byte) bool {
+	tokens, diags := hclsyntax.LexConfig(remover.DetectTextFormat(content).Normalize(content), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return false
+	}
+	for _, token := range tokens {
+		switch token.Type {
+		case hclsyntax.TokenComment, hclsyntax.TokenNewline, hclsyntax.TokenEOF:
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 // printUsage prints the usage information for the script
@@ -144,6 +494,15 @@ func printUsage() {
 	fmt.Println()
 	fmt.Println("Usage: terraform-moved-remover [options] [directory]")
 	fmt.Println("       If directory is not specified, the current directory will be used.")
+	fmt.Println("       If directory is '-', a single file is read from stdin and written to stdout.")
+	fmt.Println()
+	fmt.Println("Subcommands:")
+	fmt.Println("  lsp            Run a Language Server Protocol server over stdio")
+	fmt.Println("  generate       Generate moved blocks from renames between two directories or git revisions")
+	fmt.Println("  from-state-mv  Convert 'terraform state mv' commands in shell scripts into moved blocks")
+	fmt.Println("  undo           Revert the files modified by the last run")
+	fmt.Println("  serve          Serve an HTTP JSON API that removes moved blocks from posted files")
+	fmt.Println("  consolidate    Move the moved blocks of each module into one file")
 	fmt.Println()
 	fmt.Println("Options:")
 	flag.PrintDefaults()
@@ -151,11 +510,44 @@ func printUsage() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+	
 	helpFlag := flag.Bool("help", false, "Display help information")
 	versionFlag := flag.Bool("version", false, "Display version information")
 	dryRunFlag := flag.Bool("dry-run", false, "Run without modifying files")
 	verboseFlag := flag.Bool("verbose", false, "Enable verbose output")
-	normalizeFlag := flag.Bool("normalize-whitespace", false, "Normalize whitespace after removing moved blocks")
+	normalizeFlag := flag.Bool("normalize-whitespace", false, "Also collapse runs of blank lines elsewhere in files that had moved blocks removed")
+	failFastFlag := flag.Bool("fail-fast", false, "Stop at the first file that fails to process")
+	reconcileFlag := flag.Bool("reconcile", false, "Only remove moved blocks that every calling root module has applied, according to -state")
+	stateFiles := stateFlag{}
+	flag.Var(stateFiles, "state", "State file for a root module as [root=]path, relative to the scanned directory (repeatable, also for the same root)")
+	planFiles := stateFlag{}
+	flag.Var(planFiles, "plan-json", "terraform show -json output of a plan for a root module as [root=]path (repeatable, implies -reconcile)")
+	terragruntFlag := flag.Bool("terragrunt", false, "Also process terragrunt.hcl files and the .hcl files they include or read, including moved blocks in generate block contents")
+	includeCacheFlag := flag.Bool("include-terragrunt-cache", false, "Descend into .terragrunt-cache directories")
+	topFlag := flag.Int("top", 10, "Number of modules and files to list in the breakdown (0 to disable)")
+	keepGoingFlag := flag.Bool("keep-going", false, "Exit with status 0 even if some files fail to process")
+	filenameFlag := flag.String("filename", "", "File name to use for diagnostics when reading from stdin")
+	backup := &backupFlag{}
+	flag.Var(backup, "backup", "Keep the original of each modified file next to it, as -backup or -backup=SUFFIX (default suffix "+defaultBackupSuffix+")")
+	backupDirFlag := flag.String("backup-dir", "", "Keep the originals of modified files in a directory that mirrors the scanned tree")
+	restoreFlag := flag.Bool("restore-backups", false, "Restore files from the backups of an earlier run and remove the backups")
+	gitCommitFlag := flag.Bool("git-commit", false, "Commit the modified files to the local git repository after a successful run")
+	gitBranchFlag := flag.String("git-branch", defaultCommitBranch, "Branch to create for -git-commit (empty to commit on the current branch)")
+	gitMessageFlag := flag.String("git-message", "", "Go template for the -git-commit message (default lists the removed moves per file)")
+	verifyCmdFlag := flag.String("verify-cmd", "", "Command to run after the run in each root module that has or calls a modified module, such as \"terraform plan -detailed-exitcode\"; root modules where it fails are rolled back with the modules they call")
+	verifyTimeoutFlag := flag.Duration("verify-timeout", defaultVerifyTimeout, "Maximum time the -verify-cmd command may run in one module")
+	configFlag := flag.String("config", "", "Configuration file with keep and remove rules (default "+configFile+" in the scanned directory, or above -filename in filter mode, if it exists)")
+	pluginFlag := flag.String("decision-plugin", "", "Executable, with arguments, that is asked whether each moved block may be removed, speaking JSON over stdin and stdout")
+	sinceFlag := flag.String("since", "", "Only process files changed between this git revision and the working tree, such as origin/main")
+	cacheFlag := flag.Bool("cache", false, "Skip files that had no moved blocks and were already formatted in the last run, using "+cacheFile+" in the scanned directory")
+	deleteEmptyFlag := flag.Bool("delete-empty", false, "Delete files left with nothing but comments after their moved blocks are removed")
+	watchFlag := flag.Bool("watch", false, "Keep running and process .tf files again as they change")
+	forceFlag := flag.Bool("force", false, "Allow -git-commit on a working tree with uncommitted changes")
 	
 	flag.Usage = printUsage
 	
@@ -171,6 +563,11 @@ func main() {
 		os.Exit(0)
 	}
 	
+	if *failFastFlag && *keepGoingFlag {
+		fmt.Println("Error: -fail-fast and -keep-going cannot be used together")
+		os.Exit(1)
+	}
+	
 	args := flag.Args()
 	rootDir := "."  // Default to current directory
 	
@@ -178,6 +575,39 @@ func main() {
 		rootDir = args[0]
 	}
 	
+	if *filenameFlag != "" && rootDir != stdinPath {
+		fmt.Println("Error: -filename can only be used when reading from stdin")
+		os.Exit(1)
+	}
+	
+	// Filter mode: read from stdin and write the result to stdout
+	if rootDir == stdinPath {
+		filename := *filenameFlag
+		if filename == "" {
+			filename = defaultStdinFilename
+		}
+		stats := Stats{NormalizeWhitespace: *normalizeFlag}
+		// Paths in rules are relative to the configuration file's directory
+		if configPath := streamConfig(*configFlag, *filenameFlag); configPath != "" {
+			rules, err := loadPolicy(configPath, filepath.Dir(configPath))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+				os.Exit(1)
+			}
+			stats.Deciders = append(stats.Deciders, rules)
+		}
+		if err := processStream(os.Stdin, os.Stdout, filename, &stats); err != nil {
+			var diagErr *remover.DiagnosticsError
+			if errors.As(err, &diagErr) {
+				_ = writeDiagnostics(os.Stderr, stats.Sources, stats.Diagnostics, isTerminal(os.Stderr))
+			} else {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			}
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
 	// Verify directory exists
 	info, err := os.Stat(rootDir)
 	if err != nil {
@@ -190,30 +620,214 @@ func main() {
 		os.Exit(1)
 	}
 	
+	if backup.Suffix != "" && *backupDirFlag != "" {
+		fmt.Println("Error: -backup and -backup-dir cannot be used together")
+		os.Exit(1)
+	}
+	var backupCfg *backupConfig
+	if backup.Suffix != "" || *backupDirFlag != "" {
+		backupCfg = &backupConfig{Root: rootDir, Suffix: backup.Suffix, Dir: *backupDirFlag}
+	}
+	
+	if *restoreFlag {
+		if backupCfg == nil {
+			backupCfg = &backupConfig{Root: rootDir, Suffix: defaultBackupSuffix}
+		}
+		restored, err := restoreBackups(backupCfg)
+		for _, file := range restored {
+			fmt.Printf("Restored: %s\n", file)
+		}
+		fmt.Printf("Files restored: %d\n", len(restored))
+		if err != nil {
+			fmt.Printf("Error restoring backups: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	
+	if *watchFlag && (*gitCommitFlag || *verifyCmdFlag != "" || *cacheFlag || *sinceFlag != "") {
+		fmt.Println("Error: -watch cannot be used with -git-commit, -verify-cmd, -cache or -since")
+		os.Exit(1)
+	}
+	
+	var verifyArgs []string
+	if *verifyCmdFlag != "" {
+		if *dryRunFlag {
+			fmt.Println("Error: -verify-cmd cannot be used with -dry-run")
+			os.Exit(1)
+		}
+		verifyArgs, err = newVerifyCommand(*verifyCmdFlag)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	
+	var pluginArgs []string
+	if *pluginFlag != "" {
+		pluginArgs, err = splitCommand("decision plugin", *pluginFlag)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	
+	var committer *gitCommitter
+	if *gitCommitFlag {
+		if *dryRunFlag {
+			fmt.Println("Error: -git-commit cannot be used with -dry-run")
+			os.Exit(1)
+		}
+		messageTemplate := *gitMessageFlag
+		if messageTemplate == "" {
+			messageTemplate = defaultCommitTemplate
+		}
+		committer, err = newGitCommitter(rootDir, *gitBranchFlag, messageTemplate, *forceFlag)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	
 	// Initialize statistics
 	stats := Stats{
 		StartTime:           time.Now(),
 		DryRun:              *dryRunFlag,
 		NormalizeWhitespace: *normalizeFlag,
+		FailFast:            *failFastFlag,
+		Verbose:             *verboseFlag,
+		DeleteEmpty:         *deleteEmptyFlag,
+		Backup:              backupCfg,
+	}
+	if !stats.DryRun && !*watchFlag {
+		stats.Journal = newJournal(rootDir)
+	}
+	if verifyArgs != nil {
+		stats.Originals = make(map[string][]byte)
+	}
+	
+	// Rules from the configuration file are evaluated before any plugin
+	configPath := *configFlag
+	if configPath == "" {
+		if _, err := os.Stat(filepath.Join(rootDir, configFile)); err == nil {
+			configPath = filepath.Join(rootDir, configFile)
+		}
+	}
+	configHash := ""
+	if configPath != "" {
+		rules, err := loadPolicy(configPath, rootDir)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Loaded %d rules from %s\n", len(rules.Rules), configPath)
+		stats.Deciders = append(stats.Deciders, rules)
+		configHash = rules.Hash
 	}
 	
 	// Find all Terraform files
 	fmt.Printf("Scanning directory: %s\n", rootDir)
-	files, err := findTerraformFiles(rootDir)
+	discovery := discoveryOptions{
+		Terragrunt:             *terragruntFlag,
+		IncludeTerragruntCache: *includeCacheFlag,
+		ExcludeDirs:            backupDirs(backupCfg),
+	}
+	files, excluded, err := discoverFiles(rootDir, discovery)
 	if err != nil {
 		fmt.Printf("Error finding Terraform files: %s\n", err)
 		os.Exit(1)
 	}
 	fmt.Printf("Found %d Terraform files\n", len(files))
+	if *cacheFlag {
+		stats.Cache = loadCache(rootDir, cacheOptions(&stats, discovery, configHash))
+		if stats.Cache.Invalidated {
+			fmt.Println("Cache invalidated: it was written by another version or with other options")
+		}
+	}
+	for _, dir := range excluded {
+		fmt.Printf("Excluded: %s (Terragrunt cache)\n", dir)
+	}
 	
-	// Process each file
-	for _, file := range files {
-		if *verboseFlag {
-			fmt.Printf("Processing: %s\n", file)
+	if len(stateFiles) > 0 && !*reconcileFlag {
+		fmt.Println("Error: -state can only be used with -reconcile")
+		os.Exit(1)
+	}
+	if *reconcileFlag || len(planFiles) > 0 {
+		stats.Reconciler, err = newReconciler(rootDir, files, stateFiles, planFiles)
+		if err != nil {
+			fmt.Printf("Error building module call graph: %s\n", err)
+			os.Exit(1)
 		}
-		err := processFile(file, &stats)
+	}
+	// The verify command runs in the root modules that call the modified
+	// modules, which needs the call graph even without -reconcile
+	verifyGraph := stats.Reconciler
+	if verifyArgs != nil && verifyGraph == nil {
+		verifyGraph, err = newReconciler(rootDir, files, nil, nil)
 		if err != nil {
-			fmt.Printf("Error processing %s: %s\n", file, err)
+			fmt.Printf("Error building module call graph: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	
+	// The module call graph above is built from every file, but only the
+	// files changed since the revision are processed
+	if *sinceFlag != "" {
+		changed, err := changedSince(rootDir, *sinceFlag)
+		if err != nil {
+			fmt.Printf("Error finding files changed since %s: %s\n", *sinceFlag, err)
+			os.Exit(1)
+		}
+		files = changed.filter(files)
+		fmt.Printf("Processing %d files changed since %s\n", len(files), *sinceFlag)
+		if stats.Verbose {
+			for _, rename := range changed.Renames {
+				fmt.Printf("Renamed: %s -> %s\n", rename.From, rename.To)
+			}
+		}
+	}
+	
+	if pluginArgs != nil {
+		plugin, err := decision.StartPlugin(pluginArgs)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		stats.Deciders = append(stats.Deciders, plugin)
+	}
+	
+	// Watch mode processes files until interrupted instead of once
+	if *watchFlag {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err := newWatcher(rootDir, discovery, stats, os.Stdout).run(ctx)
+		closeDeciders(&stats)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	
+	// Process each file
+	processFiles(files, &stats)
+	closeDeciders(&stats)
+	
+	var verifyResults []verifyResult
+	if verifyArgs != nil {
+		verifyResults = verifyModules(verifyArgs, *verifyTimeoutFlag, verifyGraph, &stats)
+	}
+	
+	if stats.Journal != nil {
+		if err := stats.Journal.write(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
+		}
+	}
+	if stats.Cache != nil && !stats.DryRun {
+		if err := stats.Cache.write(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			os.Exit(1)
 		}
 	}
 	
@@ -229,5 +843,53 @@ func main() {
 	fmt.Printf("Files processed: %d\n", stats.FilesProcessed)
 	fmt.Printf("Files modified: %d\n", stats.FilesModified)
 	fmt.Printf("Moved blocks removed: %d\n", stats.MovedBlocksRemoved)
+	if stats.DeleteEmpty {
+		fmt.Printf("Files deleted: %d\n", len(stats.DeletedFiles))
+	}
+	if stats.Cache != nil {
+		fmt.Printf("Cache hits: %d\n", stats.CacheHits)
+	}
+	if len(stats.KeptBlocks) > 0 {
+		fmt.Printf("Moved blocks kept: %d\n", len(stats.KeptBlocks))
+	}
 	fmt.Printf("Processing time: %v\n", duration)
+
+	printBreakdown(&stats, *topFlag)
+	printDeletedFiles(&stats)
+	printKeptBlocks(&stats)
+	printRemovalReasons(&stats)
+	printFailures(&stats)
+	printVerifyResults(verifyResults)
+
+	if len(stats.Diagnostics) > 0 {
+		fmt.Printf("\nDiagnostics:\n\n")
+		if err := writeDiagnostics(os.Stdout, stats.Sources, stats.Diagnostics, isTerminal(os.Stdout)); err != nil {
+			fmt.Printf("Error writing diagnostics: %s\n", err)
+		}
+	}
+
+	if committer != nil {
+		if len(stats.Failures) > 0 {
+			fmt.Println("\nNot committing because some files failed to process")
+		} else if hash, err := committer.commit(&stats); err != nil {
+			fmt.Printf("\nError committing changes: %s\n", err)
+			os.Exit(1)
+		} else if hash == "" {
+			fmt.Println("\nNo changes to commit")
+		} else if committer.Branch != "" {
+			fmt.Printf("\nCommitted %s on branch %s\n", hash, committer.Branch)
+		} else {
+			fmt.Printf("\nCommitted %s\n", hash)
+		}
+	}
+
+	verifyFailed := false
+	for _, result := range verifyResults {
+		if result.Err != nil {
+			verifyFailed = true
+		}
+	}
+	if (len(stats.Failures) > 0 || verifyFailed) && !*keepGoingFlag {
+		os.Exit(1)
+	}
 }
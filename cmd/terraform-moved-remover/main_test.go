@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -397,7 +398,8 @@ func TestWhitespaceNormalizationFlag(t *testing.T) {
 	}
 	defer os.RemoveAll(tempDir)
 
-	// Test file content with consecutive moved blocks
+	// Test file content with consecutive moved blocks, and two blank lines
+	// the author added on purpose elsewhere
 	content := `
 resource "aws_instance" "web" {
   ami           = "ami-123456"
@@ -422,95 +424,78 @@ moved {
 resource "aws_s3_bucket" "data" {
   bucket = "my-bucket"
 }
+
+
+# Outputs
+output "id" {
+  value = aws_instance.web.id
+}
 `
 
-	// Test with normalization disabled
-	testFileDisabled := filepath.Join(tempDir, "normalization_disabled.tf")
-	err = os.WriteFile(testFileDisabled, []byte(content), 0644)
-	if err != nil {
-		t.Fatalf("Failed to write test file: %v", err)
-	}
+	// By default only the blank lines where blocks were removed change
+	expectedDisabled := `
+resource "aws_instance" "web" {
+  ami           = "ami-123456"
+  instance_type = "t2.micro"
+}
 
-	statsDisabled := Stats{
-		StartTime:           time.Now(),
-		NormalizeWhitespace: false,
-	}
-	err = processFile(testFileDisabled, &statsDisabled)
-	if err != nil {
-		t.Fatalf("processFile failed with normalization disabled: %v", err)
-	}
+resource "aws_s3_bucket" "data" {
+  bucket = "my-bucket"
+}
 
-	// Read the modified file
-	disabledContent, err := os.ReadFile(testFileDisabled)
-	if err != nil {
-		t.Fatalf("Failed to read modified file: %v", err)
-	}
 
-	t.Logf("Content with normalization disabled: %s", string(disabledContent))
+# Outputs
+output "id" {
+  value = aws_instance.web.id
+}
+`
 
-	// Test with normalization enabled
-	testFileEnabled := filepath.Join(tempDir, "normalization_enabled.tf")
-	err = os.WriteFile(testFileEnabled, []byte(content), 0644)
-	if err != nil {
-		t.Fatalf("Failed to write test file: %v", err)
-	}
+	// With normalization enabled, runs of blank lines are collapsed everywhere
+	expectedEnabled := `
+resource "aws_instance" "web" {
+  ami           = "ami-123456"
+  instance_type = "t2.micro"
+}
 
-	statsEnabled := Stats{
-		StartTime:           time.Now(),
-		NormalizeWhitespace: true,
-	}
-	err = processFile(testFileEnabled, &statsEnabled)
-	if err != nil {
-		t.Fatalf("processFile failed with normalization enabled: %v", err)
-	}
+resource "aws_s3_bucket" "data" {
+  bucket = "my-bucket"
+}
 
-	// Read the modified file
-	enabledContent, err := os.ReadFile(testFileEnabled)
-	if err != nil {
-		t.Fatalf("Failed to read modified file: %v", err)
-	}
+# Outputs
+output "id" {
+  value = aws_instance.web.id
+}
+`
 
-	t.Logf("Content with normalization enabled: %s", string(enabledContent))
+	for _, normalize := range []bool{false, true} {
+		testFile := filepath.Join(tempDir, fmt.Sprintf("normalization_%v.tf", normalize))
+		err = os.WriteFile(testFile, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("Failed to write test file: %v", err)
+		}
 
-	disabledLines := strings.Split(string(disabledContent), "\n")
-	disabledConsecutiveEmptyLines := 0
-	disabledMaxConsecutiveEmptyLines := 0
-	
-	for _, line := range disabledLines {
-		if strings.TrimSpace(line) == "" {
-			disabledConsecutiveEmptyLines++
-		} else {
-			if disabledConsecutiveEmptyLines > disabledMaxConsecutiveEmptyLines {
-				disabledMaxConsecutiveEmptyLines = disabledConsecutiveEmptyLines
-			}
-			disabledConsecutiveEmptyLines = 0
+		stats := Stats{
+			StartTime:           time.Now(),
+			NormalizeWhitespace: normalize,
 		}
-	}
-	
-	enabledLines := strings.Split(string(enabledContent), "\n")
-	enabledConsecutiveEmptyLines := 0
-	enabledMaxConsecutiveEmptyLines := 0
-	
-	for _, line := range enabledLines {
-		if strings.TrimSpace(line) == "" {
-			enabledConsecutiveEmptyLines++
-		} else {
-			if enabledConsecutiveEmptyLines > enabledMaxConsecutiveEmptyLines {
-				enabledMaxConsecutiveEmptyLines = enabledConsecutiveEmptyLines
-			}
-			enabledConsecutiveEmptyLines = 0
+		err = processFile(testFile, &stats)
+		if err != nil {
+			t.Fatalf("processFile failed with normalization %v: %v", normalize, err)
+		}
+
+		// Read the modified file
+		modifiedContent, err := os.ReadFile(testFile)
+		if err != nil {
+			t.Fatalf("Failed to read modified file: %v", err)
+		}
+
+		expected := expectedDisabled
+		if normalize {
+			expected = expectedEnabled
+		}
+		if string(modifiedContent) != expected {
+			t.Errorf("With normalization %v, expected content:\n%s\nActual content:\n%s", normalize, expected, string(modifiedContent))
 		}
-	}
-	
-	// With normalization disabled, we expect more consecutive empty lines
-	if disabledMaxConsecutiveEmptyLines <= enabledMaxConsecutiveEmptyLines {
-		t.Errorf("Expected more consecutive empty lines with normalization disabled, but got %d (disabled) vs %d (enabled)",
-			disabledMaxConsecutiveEmptyLines, enabledMaxConsecutiveEmptyLines)
-	}
-	
-	if enabledMaxConsecutiveEmptyLines > 2 {
-		t.Errorf("With normalization enabled, file contains %d consecutive empty lines, expected at most 1", 
-			enabledMaxConsecutiveEmptyLines-1)
 	}
 }
 
@@ -592,3 +577,121 @@ module "hoge" {
 		t.Errorf("Expected content:\n%s\nActual content:\n%s", normalizedExpected, normalizedActual)
 	}
 }
+
+func TestProcessFilesFailures(t *testing.T) {
+	tempDir := t.TempDir()
+
+	invalidFile := filepath.Join(tempDir, "a_invalid.tf")
+	if err := os.WriteFile(invalidFile, []byte("this is not valid HCL"), 0644); err != nil {
+		t.Fatalf("Failed to write invalid file: %v", err)
+	}
+	validFile := filepath.Join(tempDir, "b_valid.tf")
+	content := `
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+	if err := os.WriteFile(validFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write valid file: %v", err)
+	}
+
+	files := []string{invalidFile, validFile, filepath.Join(tempDir, "missing.tf")}
+
+	// By default every file is processed and each failure is recorded
+	stats := Stats{DryRun: true}
+	processFiles(files, &stats)
+
+	if len(stats.Failures) != 2 {
+		t.Fatalf("Expected 2 failures, but got %d", len(stats.Failures))
+	}
+	if stats.Failures[0].Path != invalidFile {
+		t.Errorf("Expected first failure to be %s, but got %s", invalidFile, stats.Failures[0].Path)
+	}
+	if stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected MovedBlocksRemoved to be 1, but got %d", stats.MovedBlocksRemoved)
+	}
+
+	// With fail-fast processing stops at the first failure
+	stats = Stats{DryRun: true, FailFast: true}
+	processFiles(files, &stats)
+
+	if len(stats.Failures) != 1 {
+		t.Fatalf("Expected 1 failure with FailFast, but got %d", len(stats.Failures))
+	}
+	if stats.FilesProcessed != 0 {
+		t.Errorf("Expected no files to be processed after the failure, but got %d", stats.FilesProcessed)
+	}
+}
+
+// TestDeleteEmpty tests that files left with nothing but comments are
+// deleted, and that files without moved blocks are never deleted
+func TestDeleteEmpty(t *testing.T) {
+	rootDir := t.TempDir()
+	moved := "moved {\n  from = aws_instance.old\n  to   = aws_instance.web\n}\n"
+	writeTestFiles(t, rootDir, map[string]string{
+		"only_moved.tf": moved,
+		"commented.tf":  "# Renames kept for the 2024 migration\n\n" + moved,
+		"main.tf":       "resource \"aws_instance\" \"web\" {}\n\n" + moved,
+		"empty.tf":      "\n\n",
+		"notes.tf":      "  # Nothing here yet, reformatted but kept\n",
+	})
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+
+	// Nothing is deleted in dry run mode, but the files are reported
+	stats := Stats{DryRun: true, DeleteEmpty: true}
+	processFiles(files, &stats)
+	if len(stats.DeletedFiles) != 2 {
+		t.Errorf("Expected 2 files to be reported as deleted, but got %v", stats.DeletedFiles)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("Expected %s to exist after a dry run, but got %v", file, err)
+		}
+	}
+
+	stats = Stats{DeleteEmpty: true, Journal: newJournal(rootDir)}
+	processFiles(files, &stats)
+
+	expected := []string{filepath.Join(rootDir, "commented.tf"), filepath.Join(rootDir, "only_moved.tf")}
+	if fmt.Sprint(stats.DeletedFiles) != fmt.Sprint(expected) {
+		t.Errorf("Expected deleted files %v, but got %v", expected, stats.DeletedFiles)
+	}
+	if stats.FilesModified != 4 || stats.MovedBlocksRemoved != 3 {
+		t.Errorf("Expected 4 files modified and 3 blocks removed, but got %d and %d", stats.FilesModified, stats.MovedBlocksRemoved)
+	}
+	for _, name := range []string{"commented.tf", "only_moved.tf"} {
+		if _, err := os.Stat(filepath.Join(rootDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted, but got %v", name, err)
+		}
+	}
+	for _, name := range []string{"main.tf", "empty.tf", "notes.tf"} {
+		if _, err := os.Stat(filepath.Join(rootDir, name)); err != nil {
+			t.Errorf("Expected %s to be kept, but got %v", name, err)
+		}
+	}
+	for _, file := range stats.Files {
+		if filepath.Base(file.Path) == "only_moved.tf" && file.Status != FileDeleted {
+			t.Errorf("Expected status %q for only_moved.tf, but got %q", FileDeleted, file.Status)
+		}
+	}
+
+	// Undo restores the deleted files
+	results, err := undoJournal(stats.Journal, false)
+	if err != nil {
+		t.Fatalf("undoJournal failed: %v", err)
+	}
+	for _, result := range results {
+		if !result.Reverted {
+			t.Errorf("Expected %s to be reverted, but got %q", result.Path, result.Reason)
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(rootDir, "only_moved.tf"))
+	if err != nil || string(content) != moved {
+		t.Errorf("Expected only_moved.tf to be restored, but got %q, %v", content, err)
+	}
+}
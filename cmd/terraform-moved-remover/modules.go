@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// isLocalModuleSource reports whether a module source refers to a directory
+// on disk rather than a registry or remote address
+func isLocalModuleSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
+
+// localModuleCalls returns the module calls in dir whose source is a local
+// path, mapped to the directory they resolve to. Files that cannot be parsed
+// are skipped, and the first of them is returned as a *remover.DiagnosticsError
+// along with the calls in the other files.
+func localModuleCalls(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	calls := make(map[string]string)
+	var parseErr error
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tf") {
+			continue
+		}
+
+		filePath := filepath.Join(dir, entry.Name())
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s: %w", filePath, err)
+		}
+		file, diags := hclsyntax.ParseConfig(content, filePath, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			if parseErr == nil {
+				parseErr = &remover.DiagnosticsError{Path: filePath, Diagnostics: diags}
+			}
+			continue
+		}
+
+		for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+			if block.Type != "module" || len(block.Labels) != 1 {
+				continue
+			}
+			attr, ok := block.Body.Attributes["source"]
+			if !ok {
+				continue
+			}
+			val, diags := attr.Expr.Value(nil)
+			if diags.HasErrors() || val.IsNull() || val.Type() != cty.String {
+				continue
+			}
+			source := val.AsString()
+			if isLocalModuleSource(source) {
+				calls[block.Labels[0]] = filepath.Join(dir, filepath.FromSlash(source))
+			}
+		}
+	}
+	return calls, parseErr
+}
+
+// resolveModulePath follows a chain of module call names, such as
+// ["network", "subnets"] for module.network.module.subnets, from rootDir and
+// returns the directory of the final module
+func resolveModulePath(rootDir string, calls []string) (string, error) {
+	dir := rootDir
+	for _, name := range calls {
+		modules, err := localModuleCalls(dir)
+		if err != nil {
+			return "", err
+		}
+		next, ok := modules[name]
+		if !ok {
+			return "", fmt.Errorf("module %q is not a local module call in %s", name, dir)
+		}
+		dir = next
+	}
+	return dir, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// planFileJSON is the subset of the output of terraform show -json for a
+// saved plan that we need to know which moves are still pending
+type planFileJSON struct {
+	FormatVersion   string `json:"format_version"`
+	ResourceChanges []struct {
+		Address         string `json:"address"`
+		PreviousAddress string `json:"previous_address"`
+	} `json:"resource_changes"`
+}
+
+// readPlanFile returns the previous addresses of every resource instance the
+// plan moves. A moved block whose from address contains one of them is still
+// needed to apply the plan.
+func readPlanFile(path string) ([]remover.Address, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan file %s: %w", path, err)
+	}
+
+	var plan planFileJSON
+	if err := json.Unmarshal(content, &plan); err != nil {
+		return nil, fmt.Errorf("error parsing plan file %s: %w", path, err)
+	}
+	if major, _, _ := strings.Cut(plan.FormatVersion, "."); major != "1" {
+		return nil, fmt.Errorf("plan file %s has unsupported format version %q (expected terraform show -json output)", path, plan.FormatVersion)
+	}
+
+	var addrs []remover.Address
+	for _, change := range plan.ResourceChanges {
+		if change.PreviousAddress == "" || change.PreviousAddress == change.Address {
+			continue
+		}
+		addr, err := remover.ParseAddress(change.PreviousAddress)
+		if err != nil {
+			return nil, fmt.Errorf("plan file %s: %w", path, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReconcilePlan tests keeping moved blocks that a plan still depends on
+func TestReconcilePlan(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"live/prod/main.tf": `
+module "app" {
+  source = "../../modules/app"
+}
+`,
+		"modules/app/main.tf": `
+resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+
+moved {
+  from = aws_s3_bucket.legacy
+  to   = aws_s3_bucket.data
+}
+`,
+		"plans/prod.json": `{
+  "format_version": "1.2",
+  "resource_changes": [
+    {"address": "module.app.aws_instance.web", "change": {"actions": ["no-op"]}},
+    {"address": "module.app.aws_s3_bucket.data[0]", "previous_address": "module.app.aws_s3_bucket.legacy[0]", "change": {"actions": ["no-op"]}}
+  ]
+}`,
+	})
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	stats := Stats{}
+	stats.Reconciler, err = newReconciler(rootDir, files, nil, map[string][]string{
+		filepath.Join("live", "prod"): {filepath.Join(rootDir, "plans", "prod.json")},
+	})
+	if err != nil {
+		t.Fatalf("newReconciler failed: %v", err)
+	}
+	processFiles(files, &stats)
+	if len(stats.Failures) > 0 {
+		t.Fatalf("Unexpected failures: %v", stats.Failures)
+	}
+
+	if stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected 1 block to be removed, but got %d", stats.MovedBlocksRemoved)
+	}
+	if len(stats.KeptBlocks) != 1 {
+		t.Fatalf("Expected 1 kept block, but got %v", stats.KeptBlocks)
+	}
+	kept := stats.KeptBlocks[0]
+	if kept.From != "aws_s3_bucket.legacy" || !kept.Plan {
+		t.Errorf("Expected aws_s3_bucket.legacy to be kept because of the plan, but got %+v", kept)
+	}
+	if kept.Reason != "still needed by "+filepath.Join("live", "prod")+" (via module.app) according to its plan" {
+		t.Errorf("Unexpected reason: %s", kept.Reason)
+	}
+
+	content, err := os.ReadFile(filepath.Join(rootDir, "modules", "app", "main.tf"))
+	if err != nil {
+		t.Fatalf("Failed to read module file: %v", err)
+	}
+	if strings.Contains(string(content), "aws_instance.old") {
+		t.Errorf("Expected the applied move to be removed:\n%s", content)
+	}
+}
+
+// TestReconcileMultiplePlans tests a root module with a plan per workspace,
+// where a move is kept while any of the plans still makes it
+func TestReconcileMultiplePlans(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"live/main.tf": `
+resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`,
+		"plans/dev.json": `{
+  "format_version": "1.2",
+  "resource_changes": [
+    {"address": "aws_instance.web", "change": {"actions": ["no-op"]}}
+  ]
+}`,
+		"plans/prod.json": `{
+  "format_version": "1.2",
+  "resource_changes": [
+    {"address": "aws_instance.web", "previous_address": "aws_instance.old", "change": {"actions": ["no-op"]}}
+  ]
+}`,
+	})
+
+	plans := stateFlag{}
+	for _, arg := range []string{"live=" + filepath.Join(rootDir, "plans", "dev.json"), "live=" + filepath.Join(rootDir, "plans", "prod.json")} {
+		if err := plans.Set(arg); err != nil {
+			t.Fatalf("Set(%q) failed: %v", arg, err)
+		}
+	}
+	if len(plans["live"]) != 2 {
+		t.Fatalf("Expected 2 plans for live, but got %v", plans)
+	}
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	stats := Stats{DryRun: true}
+	stats.Reconciler, err = newReconciler(rootDir, files, nil, plans)
+	if err != nil {
+		t.Fatalf("newReconciler failed: %v", err)
+	}
+	processFiles(files, &stats)
+	if len(stats.Failures) > 0 {
+		t.Fatalf("Unexpected failures: %v", stats.Failures)
+	}
+	if stats.MovedBlocksRemoved != 0 || len(stats.KeptBlocks) != 1 {
+		t.Fatalf("Expected the move to be kept, but got %d removed and %v kept", stats.MovedBlocksRemoved, stats.KeptBlocks)
+	}
+	if kept := stats.KeptBlocks[0]; kept.Reason != "still needed by live according to its plan" || !kept.Plan {
+		t.Errorf("Unexpected kept block: %+v", kept)
+	}
+
+	// Once the prod plan no longer makes the move, it can be removed
+	stats = Stats{DryRun: true}
+	stats.Reconciler, err = newReconciler(rootDir, files, nil, map[string][]string{
+		"live": {filepath.Join(rootDir, "plans", "dev.json")},
+	})
+	if err != nil {
+		t.Fatalf("newReconciler failed: %v", err)
+	}
+	processFiles(files, &stats)
+	if stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected the move to be removed, but got %d removed and %v kept", stats.MovedBlocksRemoved, stats.KeptBlocks)
+	}
+}
+
+// TestReadPlanFile tests reading previous addresses from plan JSON
+func TestReadPlanFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"plan.json": `{
+  "format_version": "1.0",
+  "resource_changes": [
+    {"address": "aws_instance.a", "previous_address": "aws_instance.a"},
+    {"address": "module.x[\"b\"].aws_instance.b", "previous_address": "module.y[\"b\"].aws_instance.b"}
+  ]
+}`,
+		"state.json": `{"version": 4, "resources": []}`,
+	})
+
+	addrs, err := readPlanFile(filepath.Join(dir, "plan.json"))
+	if err != nil {
+		t.Fatalf("readPlanFile failed: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0].String() != `module.y["b"].aws_instance.b` {
+		t.Errorf("Expected only the moved instance, but got %v", addrs)
+	}
+
+	if _, err := readPlanFile(filepath.Join(dir, "state.json")); err == nil {
+		t.Errorf("Expected an error for a file that is not plan JSON")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mkusaka/terraform-moved-remover/decision"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// configFile is the configuration file looked up in the scanned directory
+const configFile = ".moved-remover.hcl"
+
+// policyVariables are the variables available to rule conditions
+var policyVariables = []string{"file", "from", "to", "age", "comments", "module"}
+
+// policyRule is a keep or remove block of the configuration file. The first
+// rule whose condition is true for a moved block decides what happens to it.
+type policyRule struct {
+	Action decision.Action
+	Name   string
+	When   hcl.Expression
+	Range  hcl.Range
+}
+
+// policy is a decision.Decider that evaluates the rules of the configuration
+// file, such as:
+//
+//	keep "prod" {
+//	  when = matches(file.path, "prod/")
+//	}
+//
+//	remove "legacy" {
+//	  when = startswith(to, "module.legacy") && age > duration("30d")
+//	}
+//
+// Blocks no rule matches are removed as usual.
+type policy struct {
+	Rules []policyRule
+	// Hash identifies the configuration, so that caches written with other
+	// rules are discarded
+	Hash string
+
+	// root is the scanned directory that file paths are relative to
+	root string
+	// usesAge is set when a rule refers to age, which is looked up with
+	// git blame and only then
+	usesAge   bool
+	functions map[string]function.Function
+	now       time.Time
+}
+
+// loadPolicy reads the rules of a configuration file for a run over rootDir
+func loadPolicy(path, rootDir string) (*policy, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	return parsePolicy(content, path, rootDir)
+}
+
+// parsePolicy parses the rules of a configuration file
+func parsePolicy(content []byte, filename, rootDir string) (*policy, error) {
+	file, diags := hclsyntax.ParseConfig(content, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, &remover.DiagnosticsError{Path: filename, Diagnostics: diags}
+	}
+
+	ruleSchema := []hcl.BlockHeaderSchema{
+		{Type: string(decision.Keep), LabelNames: []string{"name"}},
+		{Type: string(decision.Remove), LabelNames: []string{"name"}},
+	}
+	bodyContent, diags := file.Body.Content(&hcl.BodySchema{Blocks: ruleSchema})
+	if diags.HasErrors() {
+		return nil, &remover.DiagnosticsError{Path: filename, Diagnostics: diags}
+	}
+
+	// The root is absolute so that paths given either way can be made
+	// relative to it
+	if abs, err := filepath.Abs(rootDir); err == nil {
+		rootDir = abs
+	}
+	p := &policy{Hash: contentHash(content), root: rootDir, functions: policyFunctions(), now: time.Now()}
+	allowed := make(map[string]bool)
+	for _, name := range policyVariables {
+		allowed[name] = true
+	}
+	for _, block := range bodyContent.Blocks {
+		ruleContent, diags := block.Body.Content(&hcl.BodySchema{
+			Attributes: []hcl.AttributeSchema{{Name: "when", Required: true}},
+		})
+		if diags.HasErrors() {
+			return nil, &remover.DiagnosticsError{Path: filename, Diagnostics: diags}
+		}
+		when := ruleContent.Attributes["when"].Expr
+		for _, traversal := range when.Variables() {
+			name := traversal.RootName()
+			if !allowed[name] {
+				return nil, fmt.Errorf("%s: rule %q refers to unknown variable %q; available variables are %s", traversal.SourceRange(), block.Labels[0], name, strings.Join(policyVariables, ", "))
+			}
+			if name == "age" {
+				p.usesAge = true
+			}
+		}
+		p.Rules = append(p.Rules, policyRule{
+			Action: decision.Action(block.Type),
+			Name:   block.Labels[0],
+			When:   when,
+			Range:  block.DefRange,
+		})
+	}
+	return p, nil
+}
+
+// Decide evaluates the rules for a moved block
+func (p *policy) Decide(block decision.Block) (decision.Result, error) {
+	if len(p.Rules) == 0 {
+		return decision.Result{Action: decision.Remove}, nil
+	}
+	ctx, err := p.evalContext(block)
+	if err != nil {
+		return decision.Result{}, err
+	}
+
+	for _, rule := range p.Rules {
+		value, diags := rule.When.Value(ctx)
+		if diags.HasErrors() {
+			return decision.Result{}, fmt.Errorf("rule %q: %s", rule.Name, diags.Error())
+		}
+		if value.IsNull() || !value.IsKnown() || value.Type() != cty.Bool {
+			return decision.Result{}, fmt.Errorf("rule %q at %s: condition must be true or false", rule.Name, rule.Range)
+		}
+		if value.True() {
+			return decision.Result{
+				Action: rule.Action,
+				Reason: fmt.Sprintf("%s rule %q matched (%s:%d)", rule.Action, rule.Name, rule.Range.Filename, rule.Range.Start.Line),
+			}, nil
+		}
+	}
+	return decision.Result{Action: decision.Remove}, nil
+}
+
+// evalContext returns the variables and functions rule conditions are
+// evaluated with for a moved block
+func (p *policy) evalContext(block decision.Block) (*hcl.EvalContext, error) {
+	rel := block.Path
+	if abs, err := filepath.Abs(block.Path); err == nil {
+		if r, err := filepath.Rel(p.root, abs); err == nil {
+			rel = r
+		}
+	}
+	rel = filepath.ToSlash(rel)
+
+	var age time.Duration
+	if p.usesAge {
+		var err error
+		if age, err = p.blockAge(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return &hcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"file": cty.ObjectVal(map[string]cty.Value{
+				"path": cty.StringVal(rel),
+				"name": cty.StringVal(filepath.Base(rel)),
+			}),
+			"from":     cty.StringVal(block.From),
+			"to":       cty.StringVal(block.To),
+			"age":      cty.NumberIntVal(int64(age / time.Second)),
+			"comments": cty.StringVal(block.Comments),
+			"module":   cty.StringVal(filepath.ToSlash(filepath.Dir(rel))),
+		},
+		Functions: p.functions,
+	}, nil
+}
+
+// blockAge returns how long ago the lines of a moved block were last
+// changed according to git blame. Blocks that are not committed yet, or in
+// files outside a git repository, have an age of 0.
+func (p *policy) blockAge(block decision.Block) (time.Duration, error) {
+	if block.Range.Start.Line == 0 {
+		return 0, nil
+	}
+	dir, name := filepath.Split(block.Path)
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := gitOutput(dir, "rev-parse", "--git-dir"); err != nil {
+		return 0, nil
+	}
+	lines := fmt.Sprintf("%d,%d", block.Range.Start.Line, block.Range.End.Line)
+	out, err := gitOutput(dir, "blame", "--porcelain", "-L", lines, "--", name)
+	if err != nil {
+		// Untracked files cannot be blamed
+		return 0, nil
+	}
+
+	var newest int64
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		value, ok := bytes.CutPrefix(line, []byte("author-time "))
+		if !ok {
+			continue
+		}
+		t, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected git blame output for %s: %q", block.Path, line)
+		}
+		if t > newest {
+			newest = t
+		}
+	}
+	age := p.now.Sub(time.Unix(newest, 0))
+	if newest == 0 || age < 0 {
+		return 0, nil
+	}
+	return age, nil
+}
+
+// policyFunctions returns the functions available to rule conditions
+func policyFunctions() map[string]function.Function {
+	regexps := make(map[string]*regexp.Regexp)
+	stringTest := func(test func(s, arg string) bool) function.Function {
+		return function.New(&function.Spec{
+			Params: []function.Parameter{{Name: "str", Type: cty.String}, {Name: "arg", Type: cty.String}},
+			Type:   function.StaticReturnType(cty.Bool),
+			Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+				return cty.BoolVal(test(args[0].AsString(), args[1].AsString())), nil
+			},
+		})
+	}
+
+	return map[string]function.Function{
+		"startswith":  stringTest(strings.HasPrefix),
+		"endswith":    stringTest(strings.HasSuffix),
+		"strcontains": stringTest(strings.Contains),
+		"lower":       stdlib.LowerFunc,
+		"upper":       stdlib.UpperFunc,
+		"matches": function.New(&function.Spec{
+			Params: []function.Parameter{{Name: "str", Type: cty.String}, {Name: "pattern", Type: cty.String}},
+			Type:   function.StaticReturnType(cty.Bool),
+			Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+				pattern := args[1].AsString()
+				re, ok := regexps[pattern]
+				if !ok {
+					var err error
+					if re, err = regexp.Compile(pattern); err != nil {
+						return cty.NilVal, function.NewArgErrorf(1, "invalid regular expression: %s", err)
+					}
+					regexps[pattern] = re
+				}
+				return cty.BoolVal(re.MatchString(args[0].AsString())), nil
+			},
+		}),
+		"duration": function.New(&function.Spec{
+			Params: []function.Parameter{{Name: "duration", Type: cty.String}},
+			Type:   function.StaticReturnType(cty.Number),
+			Impl: func(args []cty.Value, _ cty.Type) (cty.Value, error) {
+				d, err := parseAge(args[0].AsString())
+				if err != nil {
+					return cty.NilVal, function.NewArgError(0, err)
+				}
+				return cty.NumberIntVal(int64(d / time.Second)), nil
+			},
+		}),
+	}
+}
+
+// ageUnits are the units accepted by duration(), in addition to what
+// time.ParseDuration accepts
+var ageUnits = regexp.MustCompile(`^(\d+)([wd])(.*)$`)
+
+// parseAge parses a duration such as "30d", "2w" or "1d12h"
+func parseAge(s string) (time.Duration, error) {
+	var total time.Duration
+	rest := s
+	for {
+		m := ageUnits.FindStringSubmatch(rest)
+		if m == nil {
+			break
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		unit := 24 * time.Hour
+		if m[2] == "w" {
+			unit *= 7
+		}
+		total += time.Duration(n) * unit
+		rest = m[3]
+	}
+	if rest == "" {
+		if total == 0 && s != "0d" && s != "0w" {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return total, nil
+	}
+	d, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: use units such as 30d, 2w or 12h", s)
+	}
+	return total + d, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkusaka/terraform-moved-remover/decision"
+)
+
+const testPolicy = `keep "prod" {
+  when = matches(file.path, "^prod/")
+}
+
+keep "marked" {
+  when = strcontains(comments, "keep:")
+}
+
+remove "old legacy moves" {
+  when = startswith(to, "module.legacy") && age > duration("30d")
+}
+
+keep "legacy" {
+  when = module == "modules/legacy"
+}
+`
+
+// TestPolicyRules tests keeping and removing moved blocks with the rules of
+// a configuration file
+func TestPolicyRules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	rootDir := t.TempDir()
+	legacy := `moved {
+  from = module.old
+  to   = module.legacy_network
+}
+`
+	writeTestFiles(t, rootDir, map[string]string{
+		"prod/main.tf": "moved {\n  from = aws_instance.a\n  to   = aws_instance.b\n}\n",
+		"dev/main.tf": `# keep: still rolling out
+moved {
+  from = aws_instance.a
+  to   = aws_instance.b
+}
+
+moved {
+  from = aws_instance.c
+  to   = aws_instance.d
+}
+`,
+		"modules/legacy/main.tf": legacy,
+	})
+	git := func(args ...string) {
+		t.Helper()
+		if _, err := gitOutput(rootDir, args...); err != nil {
+			t.Fatalf("git failed: %v", err)
+		}
+	}
+	git("init", "-q")
+	git("config", "user.name", "test")
+	git("config", "user.email", "test@example.com")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial", "--date", time.Now().Add(-60*24*time.Hour).Format(time.RFC3339))
+
+	// A legacy move added since is too recent to remove
+	writeTestFiles(t, rootDir, map[string]string{
+		"modules/legacy/new.tf": "moved {\n  from = module.older\n  to   = module.legacy_dns\n}\n",
+	})
+
+	rules, err := parsePolicy([]byte(testPolicy), configFile, rootDir)
+	if err != nil {
+		t.Fatalf("parsePolicy failed: %v", err)
+	}
+	if len(rules.Rules) != 4 || !rules.usesAge {
+		t.Fatalf("Expected 4 rules that use age, but got %+v", rules)
+	}
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	stats := Stats{DryRun: true, Deciders: []decision.Decider{rules}}
+	processFiles(files, &stats)
+	if len(stats.Failures) > 0 {
+		t.Fatalf("Unexpected failures: %+v", stats.Failures)
+	}
+
+	kept := make(map[string]string)
+	for _, block := range stats.KeptBlocks {
+		rel, _ := filepath.Rel(rootDir, block.Path)
+		kept[filepath.ToSlash(rel)+" "+block.From] = block.Reason
+	}
+	expectedKept := map[string]string{
+		"prod/main.tf aws_instance.a":        `keep rule "prod" matched (.moved-remover.hcl:1)`,
+		"dev/main.tf aws_instance.a":         `keep rule "marked" matched (.moved-remover.hcl:5)`,
+		"modules/legacy/new.tf module.older": `keep rule "legacy" matched (.moved-remover.hcl:13)`,
+	}
+	for key, reason := range expectedKept {
+		if kept[key] != reason {
+			t.Errorf("Expected %s to be kept with reason %q, but got %q", key, reason, kept[key])
+		}
+	}
+	if len(stats.KeptBlocks) != len(expectedKept) {
+		t.Errorf("Expected %d kept blocks, but got %+v", len(expectedKept), stats.KeptBlocks)
+	}
+
+	reasons := make(map[string]string)
+	for _, block := range stats.RemovedBlocks {
+		reasons[block.From] = block.Reason
+	}
+	if len(stats.RemovedBlocks) != 2 || reasons["aws_instance.c"] != "" {
+		t.Errorf("Expected 2 removed blocks, one without a matching rule, but got %+v", stats.RemovedBlocks)
+	}
+	if reasons["module.old"] != `remove rule "old legacy moves" matched (.moved-remover.hcl:9)` {
+		t.Errorf("Expected the legacy move to be removed by rule, but got %q", reasons["module.old"])
+	}
+}
+
+// TestPolicyErrors tests rejecting invalid rules
+func TestPolicyErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"unknown variable", `keep "x" { when = block.to == "a" }`, `unknown variable "block"`},
+		{"missing condition", `keep "x" {}`, `Missing required argument`},
+		{"unknown block", `ignore "x" { when = true }`, `Unsupported block type`},
+	}
+	for _, tc := range testCases {
+		_, err := parsePolicy([]byte(tc.config), configFile, ".")
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: expected an error containing %q, but got %v", tc.name, tc.err, err)
+		}
+	}
+
+	// Conditions that are not true or false fail the file
+	rules, err := parsePolicy([]byte(`remove "x" { when = from }`), configFile, ".")
+	if err != nil {
+		t.Fatalf("parsePolicy failed: %v", err)
+	}
+	if _, err := rules.Decide(decision.Block{Path: "main.tf", From: "a.b"}); err == nil {
+		t.Errorf("Expected an error for a string condition")
+	}
+}
+
+// TestParseAge tests the durations accepted by duration()
+func TestParseAge(t *testing.T) {
+	day := 24 * time.Hour
+	testCases := []struct {
+		input    string
+		expected time.Duration
+		valid    bool
+	}{
+		{"30d", 30 * day, true},
+		{"2w", 14 * day, true},
+		{"1d12h", day + 12*time.Hour, true},
+		{"90m", 90 * time.Minute, true},
+		{"0d", 0, true},
+		{"", 0, false},
+		{"30 days", 0, false},
+		{"d", 0, false},
+	}
+	for _, tc := range testCases {
+		d, err := parseAge(tc.input)
+		if tc.valid && (err != nil || d != tc.expected) {
+			t.Errorf("parseAge(%q): expected %v, but got %v, %v", tc.input, tc.expected, d, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("parseAge(%q): expected an error, but got %v", tc.input, d)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mkusaka/terraform-moved-remover/decision"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// moduleCaller is a root module that reaches a module directory through a
+// chain of local module calls
+type moduleCaller struct {
+	Root string
+	Path []string // module call names from the root, empty for the root itself
+}
+
+// reconciler decides whether moved blocks are safe to remove across a
+// monorepo. A moved block is only removed once every root module that calls
+// its module, directly or indirectly, has applied the move according to the
+// supplied state files or plans.
+type reconciler struct {
+	rootDir string
+	// base is the scanned directory as given, for paths of root modules
+	// that are passed on to commands
+	base    string
+	callers map[string][]moduleCaller
+	// states holds the instances in all of each root's state files
+	states map[string][]remover.Address
+	// plans holds the previous addresses of the moves all of each root's
+	// plans make
+	plans map[string][]remover.Address
+}
+
+// newReconciler builds the module call graph for every module directory in
+// files, resolving local module sources, and loads the given state and plan
+// files. stateFiles and planFiles map root module directories, relative to
+// rootDir, to file paths. A root may have several, such as one per
+// workspace, and a move is pending for it while any of them still has it.
+func newReconciler(rootDir string, files []string, stateFiles, planFiles map[string][]string) (*reconciler, error) {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	r := &reconciler{
+		rootDir: absRoot,
+		base:    rootDir,
+		callers: make(map[string][]moduleCaller),
+		states:  make(map[string][]remover.Address),
+		plans:   make(map[string][]remover.Address),
+	}
+
+	// Collect the module directories and the local calls between them
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		dir, err := filepath.Abs(filepath.Dir(file))
+		if err != nil {
+			return nil, err
+		}
+		dirs[dir] = true
+	}
+
+	calls := make(map[string]map[string]string)
+	called := make(map[string]bool)
+	for dir := range dirs {
+		// Files that cannot be parsed fail when they are processed, so the
+		// graph is built from the others rather than failing the run
+		modules, err := localModuleCalls(dir)
+		var diagErr *remover.DiagnosticsError
+		if err != nil && !errors.As(err, &diagErr) {
+			return nil, err
+		}
+		calls[dir] = modules
+		for _, child := range modules {
+			called[child] = true
+		}
+	}
+
+	// Every directory that no other module calls is a root module
+	var roots []string
+	for dir := range dirs {
+		if !called[dir] {
+			roots = append(roots, dir)
+		}
+	}
+	sort.Strings(roots)
+
+	for _, root := range roots {
+		r.walk(calls, root, root, nil, map[string]bool{})
+	}
+
+	load := func(kind string, files map[string][]string, read func(string) ([]remover.Address, error), into map[string][]remover.Address) error {
+		for root, paths := range files {
+			absRoot, err := filepath.Abs(filepath.Join(rootDir, root))
+			if err != nil {
+				return err
+			}
+			for _, path := range paths {
+				if !dirs[absRoot] || called[absRoot] {
+					return fmt.Errorf("%s %s is given for %s, which is not a root module", kind, path, r.relative(absRoot))
+				}
+				addrs, err := read(path)
+				if err != nil {
+					return err
+				}
+				into[absRoot] = append(into[absRoot], addrs...)
+			}
+		}
+		return nil
+	}
+	if err := load("state file", stateFiles, readStateFile, r.states); err != nil {
+		return nil, err
+	}
+	if err := load("plan file", planFiles, readPlanFile, r.plans); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// walk records root as a caller of dir and of every module dir calls
+func (r *reconciler) walk(calls map[string]map[string]string, root, dir string, path []string, visiting map[string]bool) {
+	if visiting[dir] {
+		return
+	}
+	visiting[dir] = true
+	defer delete(visiting, dir)
+
+	r.callers[dir] = append(r.callers[dir], moduleCaller{Root: root, Path: path})
+
+	names := make([]string, 0, len(calls[dir]))
+	for name := range calls[dir] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := append(append([]string{}, path...), name)
+		r.walk(calls, root, calls[dir][name], childPath, visiting)
+	}
+}
+
+// relative returns a directory relative to the scanned root for reporting
+func (r *reconciler) relative(dir string) string {
+	rel, err := filepath.Rel(r.rootDir, dir)
+	if err != nil {
+		return dir
+	}
+	return rel
+}
+
+// rootsOf returns the root modules that reach the module in dir through
+// local module calls, or dir itself for a root module, as paths below the
+// scanned directory. A directory outside the call graph is its own root.
+func (r *reconciler) rootsOf(dir string) []string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return []string{dir}
+	}
+	seen := make(map[string]bool)
+	var roots []string
+	for _, caller := range r.callers[abs] {
+		if seen[caller.Root] {
+			continue
+		}
+		seen[caller.Root] = true
+		roots = append(roots, filepath.Join(r.base, r.relative(caller.Root)))
+	}
+	if len(roots) == 0 {
+		return []string{dir}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// evidence names the files moves are checked against, for messages such as
+// "already applied per state file"
+func (r *reconciler) evidence() string {
+	switch {
+	case len(r.states) > 0 && len(r.plans) > 0:
+		return "state files and plans"
+	case len(r.plans) > 0:
+		return "plan"
+	default:
+		return "state file"
+	}
+}
+
+// Decide keeps a moved block that a caller of its module still needs, which
+// makes the reconciler the first decider a run asks
+func (r *reconciler) Decide(block decision.Block) (decision.Result, error) {
+	if keep, reason, _ := r.keep(block.Path, block.From); keep {
+		return decision.Result{Action: decision.Keep, Reason: reason}, nil
+	}
+	return decision.Result{Action: decision.Remove}, nil
+}
+
+// keep reports whether the moved block in filePath with the given from
+// address must be kept, and why. A caller still needs the block when it has
+// neither a state file nor a plan, when its plan still moves an instance from
+// the block's from address, or when its state still contains an instance at
+// that address. plan is set when a plan is among the reasons.
+func (r *reconciler) keep(filePath, fromText string) (keep bool, reason string, plan bool) {
+	from, err := remover.ParseAddress(fromText)
+	if err != nil {
+		return true, fmt.Sprintf("cannot parse from address: %s", err), false
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(filePath))
+	if err != nil {
+		return true, err.Error(), false
+	}
+	callers := r.callers[dir]
+	if len(callers) == 0 {
+		return true, "module is not part of the scanned tree", false
+	}
+
+	var needed []string
+	for _, caller := range callers {
+		name := r.relative(caller.Root)
+		if len(caller.Path) > 0 {
+			name += " (via module." + strings.Join(caller.Path, ".module.") + ")"
+		}
+
+		moves, hasPlan := r.plans[caller.Root]
+		state, hasState := r.states[caller.Root]
+		switch {
+		case !hasPlan && !hasState && len(r.plans) == 0:
+			needed = append(needed, name+" has no state file")
+		case !hasPlan && !hasState:
+			needed = append(needed, name+" has no state file or plan")
+		case hasPlan && pendingMove(moves, caller.Path, from):
+			needed = append(needed, name+" according to its plan")
+			plan = true
+		case hasState && pendingMove(state, caller.Path, from):
+			needed = append(needed, name)
+		}
+	}
+
+	if len(needed) > 0 {
+		return true, "still needed by " + strings.Join(needed, ", "), plan
+	}
+	return false, "", false
+}
+
+// pendingMove reports whether any instance in state, or any previous address
+// in a plan, is still at the from address of a move declared in the module
+// reached through path. Instance keys of the module calls in path are
+// ignored, so every instance of the module is checked.
+func pendingMove(state []remover.Address, path []string, from remover.Address) bool {
+	for _, addr := range state {
+		if len(addr.Module) < len(path) {
+			continue
+		}
+		matches := true
+		for i, name := range path {
+			if addr.Module[i].Name != name {
+				matches = false
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+
+		local := addr
+		local.Module = addr.Module[len(path):]
+		if from.Contains(local) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestReconcile tests that moved blocks in shared modules are only removed
+// once every calling root module has applied them
+func TestReconcile(t *testing.T) {
+	newTree := func() string {
+		rootDir := t.TempDir()
+		writeTestFiles(t, rootDir, map[string]string{
+			"live/prod/main.tf": `
+module "app" {
+  source = "../../modules/app"
+}
+`,
+			"live/staging/main.tf": `
+module "app" {
+  source = "../../modules/app"
+}
+`,
+			"modules/app/main.tf": `
+resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+
+moved {
+  from = aws_s3_bucket.legacy
+  to   = aws_s3_bucket.data
+}
+`,
+			"states/prod.tfstate": `{
+  "version": 4,
+  "resources": [
+    {"module": "module.app", "mode": "managed", "type": "aws_instance", "name": "web", "instances": [{}]},
+    {"module": "module.app", "mode": "managed", "type": "aws_s3_bucket", "name": "legacy", "instances": [{"index_key": 0}]}
+  ]
+}`,
+			"states/staging.tfstate": `{
+  "version": 4,
+  "resources": [
+    {"module": "module.app", "mode": "managed", "type": "aws_instance", "name": "web", "instances": [{}]},
+    {"module": "module.app", "mode": "managed", "type": "aws_s3_bucket", "name": "data", "instances": [{}]}
+  ]
+}`,
+		})
+		return rootDir
+	}
+
+	run := func(rootDir string, states map[string][]string) (Stats, string) {
+		files, err := findTerraformFiles(rootDir)
+		if err != nil {
+			t.Fatalf("findTerraformFiles failed: %v", err)
+		}
+		stats := Stats{}
+		stats.Reconciler, err = newReconciler(rootDir, files, states, nil)
+		if err != nil {
+			t.Fatalf("newReconciler failed: %v", err)
+		}
+		processFiles(files, &stats)
+		if len(stats.Failures) > 0 {
+			t.Fatalf("Unexpected failures: %v", stats.Failures)
+		}
+		content, err := os.ReadFile(filepath.Join(rootDir, "modules", "app", "main.tf"))
+		if err != nil {
+			t.Fatalf("Failed to read module file: %v", err)
+		}
+		return stats, string(content)
+	}
+
+	// Without a state file for staging nothing can be removed
+	rootDir := newTree()
+	stats, content := run(rootDir, map[string][]string{
+		filepath.Join("live", "prod"): {filepath.Join(rootDir, "states", "prod.tfstate")},
+	})
+	if stats.MovedBlocksRemoved != 0 {
+		t.Errorf("Expected no blocks to be removed, but got %d", stats.MovedBlocksRemoved)
+	}
+	if len(stats.KeptBlocks) != 2 {
+		t.Fatalf("Expected 2 kept blocks, but got %v", stats.KeptBlocks)
+	}
+	if !strings.Contains(stats.KeptBlocks[0].Reason, filepath.Join("live", "staging")+" (via module.app) has no state file") {
+		t.Errorf("Unexpected reason: %s", stats.KeptBlocks[0].Reason)
+	}
+	if !strings.Contains(content, "aws_instance.old") {
+		t.Errorf("Expected moved block to be kept")
+	}
+
+	// With both state files, only the move prod has not applied is kept
+	rootDir = newTree()
+	stats, content = run(rootDir, map[string][]string{
+		filepath.Join("live", "prod"):    {filepath.Join(rootDir, "states", "prod.tfstate")},
+		filepath.Join("live", "staging"): {filepath.Join(rootDir, "states", "staging.tfstate")},
+	})
+	if stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected 1 block to be removed, but got %d", stats.MovedBlocksRemoved)
+	}
+	if len(stats.KeptBlocks) != 1 {
+		t.Fatalf("Expected 1 kept block, but got %v", stats.KeptBlocks)
+	}
+	kept := stats.KeptBlocks[0]
+	if kept.From != "aws_s3_bucket.legacy" {
+		t.Errorf("Expected aws_s3_bucket.legacy to be kept, but got %s", kept.From)
+	}
+	if kept.Reason != "still needed by "+filepath.Join("live", "prod")+" (via module.app)" {
+		t.Errorf("Unexpected reason: %s", kept.Reason)
+	}
+	if strings.Contains(content, "aws_instance.old") || !strings.Contains(content, "aws_s3_bucket.legacy") {
+		t.Errorf("Unexpected module content:\n%s", content)
+	}
+
+	// State files can only be given for root modules
+	files, _ := findTerraformFiles(rootDir)
+	_, err := newReconciler(rootDir, files, map[string][]string{
+		filepath.Join("modules", "app"): {filepath.Join(rootDir, "states", "prod.tfstate")},
+	}, nil)
+	if err == nil {
+		t.Errorf("Expected error for state file of a non-root module")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+)
+
+// FileStatus describes what happened to a single file
+type FileStatus string
+
+const (
+	FileRemoved     FileStatus = "removed"
+	FileReformatted FileStatus = "reformatted"
+	FileUnchanged   FileStatus = "unchanged"
+	FileSkipped     FileStatus = "skipped"
+	FileError       FileStatus = "error"
+	FileRolledBack  FileStatus = "rolled back"
+	FileDeleted     FileStatus = "deleted"
+)
+
+// FileStats records the outcome of processing a single file
+type FileStats struct {
+	Path          string
+	Status        FileStatus
+	BlocksRemoved int
+	BytesBefore   int
+	BytesAfter    int
+}
+
+// changed reports whether the run wrote or deleted the file
+func (f FileStats) changed() bool {
+	return f.Status == FileRemoved || f.Status == FileReformatted || f.Status == FileDeleted
+}
+
+// ModuleStats rolls up FileStats for a Terraform module directory
+type ModuleStats struct {
+	Dir           string
+	Files         int
+	FilesModified int
+	BlocksRemoved int
+	BytesBefore   int
+	BytesAfter    int
+}
+
+// moduleStats groups per-file results by module directory, sorted with the
+// modules that had the most moved blocks removed first
+func moduleStats(files []FileStats) []ModuleStats {
+	byDir := make(map[string]*ModuleStats)
+	for _, file := range files {
+		dir := filepath.Dir(file.Path)
+		mod, ok := byDir[dir]
+		if !ok {
+			mod = &ModuleStats{Dir: dir}
+			byDir[dir] = mod
+		}
+		mod.Files++
+		if file.changed() {
+			mod.FilesModified++
+		}
+		mod.BlocksRemoved += file.BlocksRemoved
+		mod.BytesBefore += file.BytesBefore
+		mod.BytesAfter += file.BytesAfter
+	}
+
+	modules := make([]ModuleStats, 0, len(byDir))
+	for _, mod := range byDir {
+		modules = append(modules, *mod)
+	}
+	sort.Slice(modules, func(i, j int) bool {
+		if modules[i].BlocksRemoved != modules[j].BlocksRemoved {
+			return modules[i].BlocksRemoved > modules[j].BlocksRemoved
+		}
+		return modules[i].Dir < modules[j].Dir
+	})
+	return modules
+}
+
+// topFiles returns the files with moved blocks removed, most blocks first
+func topFiles(files []FileStats) []FileStats {
+	var sorted []FileStats
+	for _, file := range files {
+		if file.BlocksRemoved > 0 {
+			sorted = append(sorted, file)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].BlocksRemoved != sorted[j].BlocksRemoved {
+			return sorted[i].BlocksRemoved > sorted[j].BlocksRemoved
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+	return sorted
+}
+
+// printBreakdown prints the modules and files with the most moved blocks
+// removed, limited to top entries each
+func printBreakdown(stats *Stats, top int) {
+	if top <= 0 || stats.MovedBlocksRemoved == 0 {
+		return
+	}
+
+	modules := moduleStats(stats.Files)
+	fmt.Printf("\nTop modules by moved blocks removed:\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  MODULE\tFILES\tMODIFIED\tBLOCKS\tBYTES BEFORE\tBYTES AFTER")
+	for i, mod := range modules {
+		if i >= top || mod.BlocksRemoved == 0 {
+			break
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%d\t%d\t%d\n", mod.Dir, mod.Files, mod.FilesModified, mod.BlocksRemoved, mod.BytesBefore, mod.BytesAfter)
+	}
+	w.Flush()
+
+	files := topFiles(stats.Files)
+	fmt.Printf("\nTop files by moved blocks removed:\n")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  FILE\tBLOCKS\tBYTES BEFORE\tBYTES AFTER")
+	for i, file := range files {
+		if i >= top {
+			break
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%d\n", file.Path, file.BlocksRemoved, file.BytesBefore, file.BytesAfter)
+	}
+	w.Flush()
+}
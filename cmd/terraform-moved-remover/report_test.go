@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileAndModuleStats tests per-file results and their module rollup
+func TestFileAndModuleStats(t *testing.T) {
+	tempDir := t.TempDir()
+
+	writeTestFiles(t, tempDir, map[string]string{
+		"main.tf": `
+moved {
+  from = aws_instance.a
+  to   = aws_instance.b
+}
+`,
+		"unformatted.tf": "resource \"aws_instance\" \"b\" {\nami = \"x\"\n}\n",
+		"clean.tf":       "resource \"aws_instance\" \"c\" {}\n",
+		"modules/vpc/main.tf": `
+moved {
+  from = aws_vpc.a
+  to   = aws_vpc.b
+}
+
+moved {
+  from = aws_subnet.a
+  to   = aws_subnet.b
+}
+`,
+		"modules/vpc/broken.tf": "this is not valid HCL",
+	})
+
+	files, err := findTerraformFiles(tempDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+
+	stats := Stats{}
+	processFiles(files, &stats)
+
+	byPath := make(map[string]FileStats)
+	for _, file := range stats.Files {
+		byPath[file.Path] = file
+	}
+
+	expectedStatus := map[string]FileStatus{
+		"main.tf":               FileRemoved,
+		"unformatted.tf":        FileReformatted,
+		"clean.tf":              FileUnchanged,
+		"modules/vpc/main.tf":   FileRemoved,
+		"modules/vpc/broken.tf": FileError,
+	}
+	for name, status := range expectedStatus {
+		file, ok := byPath[filepath.Join(tempDir, filepath.FromSlash(name))]
+		if !ok {
+			t.Errorf("Expected stats for %s", name)
+			continue
+		}
+		if file.Status != status {
+			t.Errorf("Expected %s to be %s, but got %s", name, status, file.Status)
+		}
+	}
+
+	vpc := byPath[filepath.Join(tempDir, "modules", "vpc", "main.tf")]
+	if vpc.BlocksRemoved != 2 {
+		t.Errorf("Expected 2 blocks removed from modules/vpc/main.tf, but got %d", vpc.BlocksRemoved)
+	}
+	if vpc.BytesAfter >= vpc.BytesBefore {
+		t.Errorf("Expected file to shrink, but got %d -> %d bytes", vpc.BytesBefore, vpc.BytesAfter)
+	}
+	content, _ := os.ReadFile(filepath.Join(tempDir, "modules", "vpc", "main.tf"))
+	if vpc.BytesAfter != len(content) {
+		t.Errorf("Expected BytesAfter to be %d, but got %d", len(content), vpc.BytesAfter)
+	}
+
+	modules := moduleStats(stats.Files)
+	if len(modules) != 2 {
+		t.Fatalf("Expected 2 modules, but got %v", modules)
+	}
+	if modules[0].Dir != filepath.Join(tempDir, "modules", "vpc") || modules[0].BlocksRemoved != 2 || modules[0].Files != 2 {
+		t.Errorf("Unexpected top module %+v", modules[0])
+	}
+	if modules[1].Dir != tempDir || modules[1].BlocksRemoved != 1 || modules[1].FilesModified != 2 {
+		t.Errorf("Unexpected second module %+v", modules[1])
+	}
+
+	// Files after a failure are skipped with fail-fast
+	stats = Stats{DryRun: true, FailFast: true}
+	processFiles([]string{filepath.Join(tempDir, "modules", "vpc", "broken.tf"), filepath.Join(tempDir, "clean.tf")}, &stats)
+	if len(stats.Files) != 2 || stats.Files[1].Status != FileSkipped {
+		t.Errorf("Expected the second file to be skipped, got %+v", stats.Files)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// serveOptions limits the requests the HTTP service accepts
+type serveOptions struct {
+	MaxRequestBytes int64
+	MaxFiles        int
+	Timeout         time.Duration
+}
+
+// defaultServeOptions are the limits used when no flags are given
+var defaultServeOptions = serveOptions{
+	MaxRequestBytes: 1 << 20,
+	MaxFiles:        100,
+	Timeout:         30 * time.Second,
+}
+
+// cleanRequest is the body of POST /v1/clean
+type cleanRequest struct {
+	Files               []cleanFile `json:"files"`
+	NormalizeWhitespace bool        `json:"normalize_whitespace,omitempty"`
+}
+
+// cleanFile is an HCL document sent to the service. Name is used in
+// diagnostics and decides whether the file is treated as Terragrunt
+// configuration.
+type cleanFile struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// cleanResponse is the response to POST /v1/clean, with one result per file
+// in request order
+type cleanResponse struct {
+	Files []cleanResult `json:"files"`
+}
+
+// cleanResult is the outcome for one file. Content is omitted when the file
+// could not be parsed.
+type cleanResult struct {
+	Name          string           `json:"name"`
+	Content       *string          `json:"content,omitempty"`
+	Modified      bool             `json:"modified"`
+	RemovedBlocks []removedJSON    `json:"removed_blocks"`
+	Diagnostics   []diagnosticJSON `json:"diagnostics"`
+	Error         string           `json:"error,omitempty"`
+}
+
+// removedJSON describes a removed moved block
+type removedJSON struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Text string `json:"text"`
+}
+
+// diagnosticJSON is an HCL diagnostic in JSON form
+type diagnosticJSON struct {
+	Severity string     `json:"severity"`
+	Summary  string     `json:"summary"`
+	Detail   string     `json:"detail,omitempty"`
+	Range    *rangeJSON `json:"range,omitempty"`
+}
+
+// rangeJSON is a source range with 1-based lines and columns
+type rangeJSON struct {
+	Start posJSON `json:"start"`
+	End   posJSON `json:"end"`
+}
+
+type posJSON struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// errorJSON is the body of error responses
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// newServeHandler returns the handler of the HTTP service
+func newServeHandler(opts serveOptions) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "version": Version})
+	})
+	mux.HandleFunc("/v1/clean", func(w http.ResponseWriter, r *http.Request) {
+		handleClean(w, r, opts)
+	})
+	return http.TimeoutHandler(mux, opts.Timeout, `{"error":"request timed out"}`)
+}
+
+// handleClean removes moved blocks from the files in the request
+func handleClean(w http.ResponseWriter, r *http.Request, opts serveOptions) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, errorJSON{Error: "method not allowed"})
+		return
+	}
+
+	var req cleanRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, opts.MaxRequestBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, errorJSON{Error: fmt.Sprintf("request body exceeds %d bytes", opts.MaxRequestBytes)})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: fmt.Sprintf("invalid request: %s", err)})
+		return
+	}
+	if len(req.Files) == 0 {
+		writeJSON(w, http.StatusBadRequest, errorJSON{Error: "no files given"})
+		return
+	}
+	if len(req.Files) > opts.MaxFiles {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errorJSON{Error: fmt.Sprintf("more than %d files given", opts.MaxFiles)})
+		return
+	}
+
+	var resp cleanResponse
+	for i, file := range req.Files {
+		if file.Name == "" {
+			file.Name = fmt.Sprintf("file%d.tf", i+1)
+		}
+		resp.Files = append(resp.Files, cleanDocument(file, req.NormalizeWhitespace))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// cleanDocument runs the same engine as processFile on a single document
+func cleanDocument(file cleanFile, normalizeWhitespace bool) cleanResult {
+	stats := Stats{NormalizeWhitespace: normalizeWhitespace}
+	result := cleanResult{Name: file.Name, RemovedBlocks: []removedJSON{}}
+
+	content, _, err := removeMovedBlocks(file.Name, []byte(file.Content), &stats)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		text := string(content)
+		result.Content = &text
+		result.Modified = text != file.Content
+	}
+	for _, block := range stats.RemovedBlocks {
+		result.RemovedBlocks = append(result.RemovedBlocks, removedJSON{From: block.From, To: block.To, Text: block.Text})
+	}
+	result.Diagnostics = diagnosticsJSON(stats.Diagnostics)
+	return result
+}
+
+// diagnosticsJSON converts HCL diagnostics to their JSON form
+func diagnosticsJSON(diags hcl.Diagnostics) []diagnosticJSON {
+	out := []diagnosticJSON{}
+	for _, diag := range diags {
+		d := diagnosticJSON{Severity: "error", Summary: diag.Summary, Detail: diag.Detail}
+		if diag.Severity == hcl.DiagWarning {
+			d.Severity = "warning"
+		}
+		if diag.Subject != nil {
+			d.Range = &rangeJSON{
+				Start: posJSON{Line: diag.Subject.Start.Line, Column: diag.Subject.Start.Column, Byte: diag.Subject.Start.Byte},
+				End:   posJSON{Line: diag.Subject.End.Line, Column: diag.Subject.End.Column, Byte: diag.Subject.End.Byte},
+			}
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// writeJSON writes v as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// runServe implements the serve subcommand, an HTTP JSON API for removing
+// moved blocks from files sent by other tools
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addrFlag := fs.String("addr", "127.0.0.1:8080", "Address to listen on")
+	maxBytesFlag := fs.Int64("max-request-bytes", defaultServeOptions.MaxRequestBytes, "Maximum size of a request body in bytes")
+	maxFilesFlag := fs.Int("max-files", defaultServeOptions.MaxFiles, "Maximum number of files in a request")
+	timeoutFlag := fs.Duration("timeout", defaultServeOptions.Timeout, "Maximum time to handle a request")
+	fs.Usage = func() {
+		fmt.Println("Usage: terraform-moved-remover serve [options]")
+		fmt.Println("       Serves POST /v1/clean, which takes {\"files\": [{\"name\", \"content\"}]}")
+		fmt.Println("       and returns the contents without moved blocks, the removed blocks")
+		fmt.Println("       and diagnostics for each file.")
+		fmt.Println()
+		fmt.Println("Options:")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	opts := serveOptions{MaxRequestBytes: *maxBytesFlag, MaxFiles: *maxFilesFlag, Timeout: *timeoutFlag}
+	server := &http.Server{
+		Addr:              *addrFlag,
+		Handler:           newServeHandler(opts),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       opts.Timeout,
+		WriteTimeout:      opts.Timeout + 5*time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    64 << 10,
+	}
+
+	fmt.Printf("Listening on %s\n", *addrFlag)
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
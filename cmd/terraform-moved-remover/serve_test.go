@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postClean sends a request body to /v1/clean and returns the response
+func postClean(t *testing.T, server *httptest.Server, body string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Post(server.URL+"/v1/clean", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return resp, data
+}
+
+// TestServeClean tests cleaning files through the HTTP API
+func TestServeClean(t *testing.T) {
+	server := httptest.NewServer(newServeHandler(defaultServeOptions))
+	defer server.Close()
+
+	req := cleanRequest{Files: []cleanFile{
+		{Name: "main.tf", Content: "resource \"aws_instance\" \"web\" {}\n\nmoved {\n  from = aws_instance.old\n  to   = aws_instance.web\n}\n"},
+		{Name: "broken.tf", Content: "resource \"aws_instance\" \"web\" {\n"},
+		{Content: "locals {}\n"},
+	}}
+	body, _ := json.Marshal(req)
+	resp, data := postClean(t, server, string(body))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, but got %d: %s", resp.StatusCode, data)
+	}
+
+	var result cleanResponse
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("Failed to parse response: %v\n%s", err, data)
+	}
+	if len(result.Files) != 3 {
+		t.Fatalf("Expected 3 results, but got %d", len(result.Files))
+	}
+
+	main := result.Files[0]
+	if main.Content == nil || *main.Content != "resource \"aws_instance\" \"web\" {}\n" || !main.Modified {
+		t.Errorf("Unexpected result for main.tf: %+v", main)
+	}
+	if len(main.RemovedBlocks) != 1 || main.RemovedBlocks[0].From != "aws_instance.old" {
+		t.Errorf("Expected the removed block, but got %+v", main.RemovedBlocks)
+	}
+
+	broken := result.Files[1]
+	if broken.Content != nil || broken.Error == "" {
+		t.Errorf("Expected an error for broken.tf, but got %+v", broken)
+	}
+	if len(broken.Diagnostics) == 0 || broken.Diagnostics[0].Severity != "error" || broken.Diagnostics[0].Range == nil {
+		t.Errorf("Expected an error diagnostic with a range, but got %+v", broken.Diagnostics)
+	}
+
+	unnamed := result.Files[2]
+	if unnamed.Name != "file3.tf" || unnamed.Modified {
+		t.Errorf("Unexpected result for the unnamed file: %+v", unnamed)
+	}
+}
+
+// TestServeLimits tests rejecting invalid and oversized requests
+func TestServeLimits(t *testing.T) {
+	server := httptest.NewServer(newServeHandler(serveOptions{MaxRequestBytes: 256, MaxFiles: 2, Timeout: time.Second}))
+	defer server.Close()
+
+	testCases := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"invalid JSON", "{", http.StatusBadRequest},
+		{"unknown field", `{"files": [], "extra": 1}`, http.StatusBadRequest},
+		{"no files", `{"files": []}`, http.StatusBadRequest},
+		{"too many files", `{"files": [{"content": ""}, {"content": ""}, {"content": ""}]}`, http.StatusRequestEntityTooLarge},
+		{"body too large", `{"files": [{"content": "` + strings.Repeat("x", 300) + `"}]}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range testCases {
+		resp, data := postClean(t, server, tc.body)
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s: expected status %d, but got %d: %s", tc.name, tc.status, resp.StatusCode, data)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/v1/clean")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, but got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// shellCommand is a simple command found in a shell script
+type shellCommand struct {
+	Line int
+	Args []string
+	// Expanded is set when an argument relies on unquoted variable or
+	// command expansion, which we cannot evaluate
+	Expanded bool
+}
+
+// parseShellScript splits a POSIX shell script into simple commands. It
+// understands quoting, backslash escapes, line continuations, comments and
+// the ;, &&, || and | separators. Anything more involved, such as control
+// flow, is returned as ordinary words.
+func parseShellScript(script string) ([]shellCommand, error) {
+	var commands []shellCommand
+	var current shellCommand
+	var word strings.Builder
+	inWord := false
+	line := 1
+
+	endWord := func() {
+		if inWord {
+			current.Args = append(current.Args, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endCommand := func() {
+		endWord()
+		if len(current.Args) > 0 {
+			commands = append(commands, current)
+		}
+		current = shellCommand{Line: line}
+	}
+	current.Line = line
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '\\':
+			if i+1 < len(runes) && runes[i+1] == '\n' {
+				// Line continuation
+				i++
+				line++
+				continue
+			}
+			if i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		case c == '\'':
+			i++
+			start := i
+			for ; i < len(runes) && runes[i] != '\''; i++ {
+				if runes[i] == '\n' {
+					line++
+				}
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated single quote")
+			}
+			word.WriteString(string(runes[start:i]))
+			inWord = true
+		case c == '"':
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				switch {
+				case runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]):
+					i++
+					if runes[i] != '\n' {
+						word.WriteRune(runes[i])
+					}
+				case runes[i] == '$' || runes[i] == '`':
+					current.Expanded = true
+					word.WriteRune(runes[i])
+				default:
+					word.WriteRune(runes[i])
+				}
+				if runes[i] == '\n' {
+					line++
+				}
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inWord = true
+		case c == '#' && !inWord:
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			i--
+		case c == '\n':
+			endCommand()
+			line++
+			current.Line = line
+		case c == ';' || c == '&' || c == '|':
+			endCommand()
+			if i+1 < len(runes) && (runes[i+1] == '&' || runes[i+1] == '|') {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\r':
+			endWord()
+		default:
+			if c == '$' || c == '`' {
+				current.Expanded = true
+			}
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	endCommand()
+
+	return commands, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// changedFiles holds the files changed between a git revision and the
+// working tree, for -since
+type changedFiles struct {
+	// Repo is the top level directory of the repository
+	Repo string
+	// Paths holds the changed files as git prints them, relative to Repo.
+	// Renamed files are listed under their new name.
+	Paths   map[string]bool
+	Renames []fileRename
+}
+
+// fileRename is a file git detected as renamed since the revision
+type fileRename struct {
+	From string
+	To   string
+}
+
+// changedSince lists the files changed between ref and the working tree of
+// the repository containing rootDir: files modified, added, copied or
+// renamed since ref, whether committed, staged or not, and untracked files
+// that are not ignored. Deleted files are left out, as there is nothing left
+// to process.
+func changedSince(rootDir, ref string) (*changedFiles, error) {
+	out, err := gitOutput(rootDir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	c := &changedFiles{Repo: strings.TrimSpace(string(out)), Paths: make(map[string]bool)}
+
+	if _, err := gitOutput(c.Repo, "rev-parse", "--verify", "--quiet", ref+"^{commit}"); err != nil {
+		return nil, fmt.Errorf("unknown revision %q", ref)
+	}
+
+	// With -z, each entry is the status followed by one path, or two for
+	// renames and copies
+	out, err = gitOutput(c.Repo, "diff", "-z", "--name-status", "-M", ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	fields := splitNUL(out)
+	for i := 0; i < len(fields); i++ {
+		status := fields[i]
+		switch {
+		case status == "":
+			continue
+		case status[0] == 'R' || status[0] == 'C':
+			if i+2 >= len(fields) {
+				return nil, fmt.Errorf("unexpected git diff output for %s", status)
+			}
+			from, to := fields[i+1], fields[i+2]
+			i += 2
+			c.Paths[to] = true
+			if status[0] == 'R' {
+				c.Renames = append(c.Renames, fileRename{From: from, To: to})
+			}
+		default:
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("unexpected git diff output for %s", status)
+			}
+			path := fields[i+1]
+			i++
+			if status[0] != 'D' {
+				c.Paths[path] = true
+			}
+		}
+	}
+
+	out, err = gitOutput(c.Repo, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range splitNUL(out) {
+		if path != "" {
+			c.Paths[path] = true
+		}
+	}
+	return c, nil
+}
+
+// splitNUL splits NUL terminated git output
+func splitNUL(out []byte) []string {
+	var fields []string
+	for _, field := range bytes.Split(bytes.TrimSuffix(out, []byte{0}), []byte{0}) {
+		fields = append(fields, string(field))
+	}
+	return fields
+}
+
+// filter returns the files that changed, in their original order
+func (c *changedFiles) filter(files []string) []string {
+	var changed []string
+	for _, file := range files {
+		if c.Paths[repoRelative(c.Repo, file)] {
+			changed = append(changed, file)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestChangedSince tests finding the files changed between a revision and
+// the working tree, including renames and untracked files
+func TestChangedSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repoDir := t.TempDir()
+	body := "resource \"aws_instance\" \"web\" {\n  ami           = \"ami-123456\"\n  instance_type = \"t2.micro\"\n}\n"
+	writeTestFiles(t, repoDir, map[string]string{
+		"infra/committed.tf": "resource \"aws_vpc\" \"main\" {}\n",
+		"infra/modified.tf":  "resource \"aws_subnet\" \"a\" {}\n",
+		"infra/old.tf":       body,
+		"infra/deleted.tf":   "resource \"aws_eip\" \"a\" {}\n",
+		"infra/same.tf":      "resource \"aws_eip\" \"b\" {}\n",
+		"other/main.tf":      "resource \"aws_eip\" \"c\" {}\n",
+		".gitignore":         "ignored.tf\n",
+	})
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := gitOutput(repoDir, args...)
+		if err != nil {
+			t.Fatalf("git failed: %v", err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("config", "user.name", "test")
+	git("config", "user.email", "test@example.com")
+	git("add", "-A")
+	git("commit", "-q", "-m", "initial")
+	base := git("rev-parse", "HEAD")
+
+	// A committed change, a staged rename, an unstaged change, a deletion,
+	// an untracked file and an ignored file
+	writeTestFiles(t, repoDir, map[string]string{"infra/committed.tf": "resource \"aws_vpc\" \"other\" {}\n"})
+	git("commit", "-q", "-am", "change")
+	git("mv", "infra/old.tf", "infra/new.tf")
+	writeTestFiles(t, repoDir, map[string]string{
+		"infra/modified.tf": "resource \"aws_subnet\" \"b\" {}\n",
+		"infra/added.tf":    "resource \"aws_eip\" \"d\" {}\n",
+		"infra/ignored.tf":  "resource \"aws_eip\" \"e\" {}\n",
+	})
+	if err := os.Remove(filepath.Join(repoDir, "infra", "deleted.tf")); err != nil {
+		t.Fatalf("Failed to delete file: %v", err)
+	}
+
+	rootDir := filepath.Join(repoDir, "infra")
+	changed, err := changedSince(rootDir, base)
+	if err != nil {
+		t.Fatalf("changedSince failed: %v", err)
+	}
+	if len(changed.Renames) != 1 || changed.Renames[0] != (fileRename{From: "infra/old.tf", To: "infra/new.tf"}) {
+		t.Errorf("Expected the rename of old.tf, but got %+v", changed.Renames)
+	}
+
+	files, err := findTerraformFiles(rootDir)
+	if err != nil {
+		t.Fatalf("findTerraformFiles failed: %v", err)
+	}
+	var names []string
+	for _, file := range changed.filter(files) {
+		names = append(names, filepath.Base(file))
+	}
+	expected := []string{"added.tf", "committed.tf", "modified.tf", "new.tf"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Errorf("Expected changed files %v, but got %v", expected, names)
+	}
+
+	if _, err := changedSince(rootDir, "no-such-ref"); err == nil {
+		t.Errorf("Expected an error for an unknown revision")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mkusaka/terraform-moved-remover/remover"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// stateFileJSON is the subset of the Terraform state format (version 4) that
+// we need to know which resource instances exist
+type stateFileJSON struct {
+	Version   int `json:"version"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey any `json:"index_key"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// readStateFile returns the addresses of every resource instance recorded in
+// a Terraform state file, as written by terraform state pull
+func readStateFile(path string) ([]remover.Address, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file %s: %w", path, err)
+	}
+
+	var state stateFileJSON
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+	if err := dec.Decode(&state); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("state file %s has unsupported version %d", path, state.Version)
+	}
+
+	var addrs []remover.Address
+	for _, res := range state.Resources {
+		var addr remover.Address
+		if res.Module != "" {
+			module, err := remover.ParseAddress(res.Module)
+			if err != nil || !module.IsModule() {
+				return nil, fmt.Errorf("state file %s has invalid module address %q", path, res.Module)
+			}
+			addr.Module = module.Module
+		}
+		addr.Mode = res.Mode
+		addr.Type = res.Type
+		addr.Name = res.Name
+
+		for _, inst := range res.Instances {
+			instAddr := addr
+			var err error
+			switch key := inst.IndexKey.(type) {
+			case nil:
+			case json.Number:
+				instAddr.Key, err = remover.InstanceKey(cty.MustParseNumberVal(key.String()))
+			case string:
+				instAddr.Key, err = remover.InstanceKey(cty.StringVal(key))
+			default:
+				err = fmt.Errorf("unsupported index key %v", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("state file %s: %s: %w", path, addr, err)
+			}
+			addrs = append(addrs, instAddr)
+		}
+	}
+	return addrs, nil
+}
+
+// stateFlag collects -state and -plan-json arguments of the form
+// [root=]path. Without a root the file belongs to the scanned directory. A
+// root can be given several files, such as the plans of each workspace.
+type stateFlag map[string][]string
+
+func (f stateFlag) String() string {
+	var parts []string
+	for root, paths := range f {
+		for _, path := range paths {
+			parts = append(parts, root+"="+path)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f stateFlag) Set(value string) error {
+	root, path, ok := strings.Cut(value, "=")
+	if !ok {
+		root, path = "", value
+	}
+	if path == "" {
+		return fmt.Errorf("missing file path in %q", value)
+	}
+	f[root] = append(f[root], path)
+	return nil
+}
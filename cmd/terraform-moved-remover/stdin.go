@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// stdinPath is the path argument that selects filter mode
+const stdinPath = "-"
+
+// defaultStdinFilename is used in diagnostics when -filename is not given
+const defaultStdinFilename = "<stdin>"
+
+// processStream reads a single HCL document from r, removes its moved blocks
+// and writes the result to w. It is used for editor integrations that pipe
+// the buffer through the tool.
+func processStream(r io.Reader, w io.Writer, filename string, stats *Stats) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", filename, err)
+	}
+
+	formattedContent, movedBlocksCount, err := removeMovedBlocks(filename, content, stats)
+	if err != nil {
+		return err
+	}
+
+	stats.FilesProcessed++
+	if movedBlocksCount > 0 {
+		stats.FilesModified++
+		stats.MovedBlocksRemoved += movedBlocksCount
+	}
+
+	if _, err := w.Write(formattedContent); err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	return nil
+}
+
+// streamConfig returns the configuration file that applies to a file read
+// from stdin: the one given with -config, or else the nearest one in the
+// directory of the -filename or above it. It returns an empty path when
+// there is none.
+func streamConfig(configPath, filename string) string {
+	if configPath != "" || filename == "" {
+		return configPath
+	}
+	dir, err := filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, configFile)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mkusaka/terraform-moved-remover/decision"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// TestProcessStream tests filter mode used by editor integrations
+func TestProcessStream(t *testing.T) {
+	input := `resource "aws_instance" "web" {
+ami = "ami-123456"
+}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+	var out bytes.Buffer
+	stats := Stats{NormalizeWhitespace: true}
+	if err := processStream(strings.NewReader(input), &out, "main.tf", &stats); err != nil {
+		t.Fatalf("processStream failed: %v", err)
+	}
+
+	expected := `resource "aws_instance" "web" {
+  ami = "ami-123456"
+}
+`
+	if out.String() != expected {
+		t.Errorf("Expected output:\n%s\nActual output:\n%s", expected, out.String())
+	}
+	if stats.MovedBlocksRemoved != 1 {
+		t.Errorf("Expected MovedBlocksRemoved to be 1, but got %d", stats.MovedBlocksRemoved)
+	}
+
+	// Diagnostics should refer to the name given with -filename
+	out.Reset()
+	stats = Stats{}
+	err := processStream(strings.NewReader("moved {\n  from = = a.b\n}\n"), &out, "modules/vpc/main.tf", &stats)
+	var diagErr *remover.DiagnosticsError
+	if !errors.As(err, &diagErr) {
+		t.Fatalf("Expected *remover.DiagnosticsError, but got %v", err)
+	}
+	if stats.Diagnostics[0].Subject.Filename != "modules/vpc/main.tf" {
+		t.Errorf("Expected diagnostics for modules/vpc/main.tf, but got %s", stats.Diagnostics[0].Subject.Filename)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no output on parse failure, but got %q", out.String())
+	}
+}
+
+// TestStreamConfig tests applying the rules of the configuration file found
+// above the file named with -filename in filter mode
+func TestStreamConfig(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		configFile:        "keep \"prod\" {\n  when = module == \"prod\"\n}\n",
+		"other/rules.hcl": "keep \"none\" {\n  when = false\n}\n",
+	})
+	filename := filepath.Join(rootDir, "prod", "main.tf")
+
+	configPath := streamConfig("", filename)
+	if configPath != filepath.Join(rootDir, configFile) {
+		t.Fatalf("Expected the configuration file in %s, but got %q", rootDir, configPath)
+	}
+	if path := streamConfig(filepath.Join(rootDir, "other", "rules.hcl"), filename); path != filepath.Join(rootDir, "other", "rules.hcl") {
+		t.Errorf("Expected -config to take precedence, but got %q", path)
+	}
+	if path := streamConfig("", ""); path != "" {
+		t.Errorf("Expected no configuration file without -filename, but got %q", path)
+	}
+
+	rules, err := loadPolicy(configPath, filepath.Dir(configPath))
+	if err != nil {
+		t.Fatalf("loadPolicy failed: %v", err)
+	}
+	input := "moved {\n  from = aws_instance.old\n  to   = aws_instance.web\n}\n"
+	var out bytes.Buffer
+	stats := Stats{Deciders: []decision.Decider{rules}}
+	if err := processStream(strings.NewReader(input), &out, filename, &stats); err != nil {
+		t.Fatalf("processStream failed: %v", err)
+	}
+	if out.String() != input {
+		t.Errorf("Expected the moved block to be kept, but got:\n%s", out.String())
+	}
+	if len(stats.KeptBlocks) != 1 || !strings.Contains(stats.KeptBlocks[0].Reason, `keep rule "prod" matched`) {
+		t.Errorf("Expected the block to be kept by the prod rule, but got %+v", stats.KeptBlocks)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// terragruntCacheDir is the directory Terragrunt copies modules into. Its
+// contents are generated, so it is excluded from discovery by default.
+const terragruntCacheDir = ".terragrunt-cache"
+
+// terragruntConfigFile is the name of a Terragrunt unit configuration
+const terragruntConfigFile = "terragrunt.hcl"
+
+// isTerragruntFile reports whether a file being processed is a Terragrunt
+// configuration file. Discovery only returns terragrunt.hcl files and the
+// files they pull in, so any other .hcl file is one of those.
+func isTerragruntFile(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasSuffix(base, ".hcl") && base != ".terraform.lock.hcl" && base != configFile
+}
+
+// addTerragruntIncludes adds the configuration files that terragrunt.hcl
+// files pull in through include blocks and read_terragrunt_config, and the
+// files those pull in in turn. Missing files are left for Terragrunt to
+// report.
+func addTerragruntIncludes(files []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var queue []string
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		seen[abs] = true
+		if filepath.Base(file) == terragruntConfigFile {
+			queue = append(queue, file)
+		}
+	}
+
+	var added []string
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		refs, err := terragruntReferences(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			abs, err := filepath.Abs(ref)
+			if err != nil {
+				return nil, err
+			}
+			if seen[abs] || !isTerragruntFile(ref) {
+				continue
+			}
+			if info, err := os.Stat(ref); err != nil || info.IsDir() {
+				continue
+			}
+			seen[abs] = true
+			added = append(added, ref)
+			queue = append(queue, ref)
+		}
+	}
+
+	sort.Strings(added)
+	return append(files, added...), nil
+}
+
+// terragruntReferences returns the files a Terragrunt configuration pulls in
+// through the path of include blocks and read_terragrunt_config calls.
+// Relative paths are resolved from the file's directory. Expressions that use
+// anything but find_in_parent_folders and get_terragrunt_dir are ignored.
+func terragruntReferences(file string) ([]string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file %s: %w", file, err)
+	}
+	parsed, diags := hclsyntax.ParseConfig(content, file, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, &remover.DiagnosticsError{Path: file, Diagnostics: diags}
+	}
+
+	dir := filepath.Dir(file)
+	ctx := terragruntEvalContext(dir)
+	var refs []string
+	add := func(expr hclsyntax.Expression) {
+		val, diags := expr.Value(ctx)
+		if diags.HasErrors() || !val.IsWhollyKnown() || val.IsNull() || val.Type() != cty.String {
+			return
+		}
+		path := filepath.FromSlash(val.AsString())
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		refs = append(refs, filepath.Clean(path))
+	}
+
+	body := parsed.Body.(*hclsyntax.Body)
+	for _, block := range body.Blocks {
+		if block.Type != "include" {
+			continue
+		}
+		if attr, ok := block.Body.Attributes["path"]; ok {
+			add(attr.Expr)
+		}
+	}
+	hclsyntax.VisitAll(body, func(node hclsyntax.Node) hcl.Diagnostics {
+		if call, ok := node.(*hclsyntax.FunctionCallExpr); ok && call.Name == "read_terragrunt_config" && len(call.Args) > 0 {
+			add(call.Args[0])
+		}
+		return nil
+	})
+	return refs, nil
+}
+
+// terragruntEvalContext provides the Terragrunt functions commonly used to
+// locate shared configuration, evaluated for a file in dir
+func terragruntEvalContext(dir string) *hcl.EvalContext {
+	return &hcl.EvalContext{
+		Functions: map[string]function.Function{
+			"get_terragrunt_dir": function.New(&function.Spec{
+				Type: function.StaticReturnType(cty.String),
+				Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+					return cty.StringVal(filepath.ToSlash(dir)), nil
+				},
+			}),
+			// find_in_parent_folders(name, fallback) searches the parent
+			// directories of dir for name, terragrunt.hcl by default
+			"find_in_parent_folders": function.New(&function.Spec{
+				VarParam: &function.Parameter{Name: "args", Type: cty.String},
+				Type:     function.StaticReturnType(cty.String),
+				Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+					name := terragruntConfigFile
+					if len(args) > 0 {
+						name = args[0].AsString()
+					}
+					for current := filepath.Dir(dir); ; current = filepath.Dir(current) {
+						path := filepath.Join(current, filepath.FromSlash(name))
+						if _, err := os.Stat(path); err == nil {
+							return cty.StringVal(filepath.ToSlash(path)), nil
+						}
+						if filepath.Dir(current) == current {
+							break
+						}
+					}
+					if len(args) > 1 {
+						return args[1], nil
+					}
+					return cty.NilVal, fmt.Errorf("%s not found in the parent folders of %s", name, dir)
+				},
+			}),
+		},
+	}
+}
+
+// addTerragruntSources adds the .tf files of local module sources referenced
+// from terraform blocks in terragrunt.hcl files that were not discovered yet
+func addTerragruntSources(files []string) ([]string, error) {
+	seen := make(map[string]bool)
+	for _, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		seen[abs] = true
+	}
+
+	var added []string
+	for _, file := range files {
+		if filepath.Base(file) != terragruntConfigFile {
+			continue
+		}
+		dir, ok, err := terragruntSourceDir(file)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading module source %s referenced from %s: %w", dir, file, err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".tf") {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[abs] {
+				seen[abs] = true
+				added = append(added, path)
+			}
+		}
+	}
+
+	sort.Strings(added)
+	return append(files, added...), nil
+}
+
+// terragruntSourceDir returns the directory of the local module source in a
+// terragrunt.hcl file. Sources that are remote or use functions are ignored.
+func terragruntSourceDir(file string) (string, bool, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", false, fmt.Errorf("error reading file %s: %w", file, err)
+	}
+	parsed, diags := hclsyntax.ParseConfig(content, file, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return "", false, &remover.DiagnosticsError{Path: file, Diagnostics: diags}
+	}
+
+	for _, block := range parsed.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "terraform" {
+			continue
+		}
+		attr, ok := block.Body.Attributes["source"]
+		if !ok {
+			continue
+		}
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || val.IsNull() || val.Type() != cty.String {
+			return "", false, nil
+		}
+
+		source := val.AsString()
+		if !isLocalModuleSource(source) {
+			return "", false, nil
+		}
+		// "../modules//app" refers to the app directory inside ../modules
+		base, subdir, _ := strings.Cut(source, "//")
+		return filepath.Join(filepath.Dir(file), filepath.FromSlash(base), filepath.FromSlash(subdir)), true, nil
+	}
+	return "", false, nil
+}
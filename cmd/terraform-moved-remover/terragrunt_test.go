@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestTerragruntDiscovery tests discovery of Terragrunt configs, the files
+// they include or read, module sources and excluded cache directories
+func TestTerragruntDiscovery(t *testing.T) {
+	rootDir := t.TempDir()
+	liveDir := filepath.Join(rootDir, "live")
+	writeTestFiles(t, rootDir, map[string]string{
+		"live/root.hcl":     "locals {}\n",
+		"live/prod/env.hcl": "locals {\n  env = \"prod\"\n}\n",
+		"live/prod/app/terragrunt.hcl": `
+include "root" {
+  path = find_in_parent_folders("root.hcl")
+}
+
+terraform {
+  source = "../../../modules//app"
+}
+`,
+		"live/prod/app/.terraform.lock.hcl":             "provider \"x\" {}\n",
+		"live/prod/app/.terragrunt-cache/abc/main.tf":   "resource \"a\" \"b\" {}\n",
+		"live/prod/app/.terragrunt-cache/abc/other.hcl": "x = 1\n",
+		"live/prod/db/terragrunt.hcl": `locals {
+  env = read_terragrunt_config("${get_terragrunt_dir()}/../env.hcl")
+}
+
+terraform {
+  source = "git::https://example.com/modules.git//db"
+}
+`,
+		"live/.moved-remover.hcl":       "keep \"all\" {\n  when = true\n}\n",
+		"live/images/build.pkr.hcl":     "source \"null\" \"x\" {}\n",
+		"modules/app/main.tf":           "resource \"aws_instance\" \"web\" {}\n",
+		"modules/app/nested/ignored.tf": "resource \"aws_instance\" \"x\" {}\n",
+	})
+
+	files, excluded, err := discoverFiles(liveDir, discoveryOptions{Terragrunt: true})
+	if err != nil {
+		t.Fatalf("discoverFiles failed: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(liveDir, "prod", "app", "terragrunt.hcl"),
+		filepath.Join(liveDir, "prod", "db", "terragrunt.hcl"),
+		filepath.Join(liveDir, "prod", "env.hcl"),
+		filepath.Join(liveDir, "root.hcl"),
+		filepath.Join(rootDir, "modules", "app", "main.tf"),
+	}
+	if len(files) != len(expected) {
+		t.Fatalf("Expected files %v, but got %v", expected, files)
+	}
+	for i := range expected {
+		if files[i] != expected[i] {
+			t.Errorf("Expected %s, but got %s", expected[i], files[i])
+		}
+	}
+
+	if len(excluded) != 1 || filepath.Base(excluded[0]) != terragruntCacheDir {
+		t.Errorf("Expected the Terragrunt cache to be excluded, but got %v", excluded)
+	}
+
+	// Without Terragrunt discovery only .tf files are found, and the cache
+	// is still excluded
+	files, _, err = discoverFiles(liveDir, discoveryOptions{})
+	if err != nil {
+		t.Fatalf("discoverFiles failed: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no files without Terragrunt discovery, but got %v", files)
+	}
+
+	files, excluded, err = discoverFiles(liveDir, discoveryOptions{IncludeTerragruntCache: true})
+	if err != nil {
+		t.Fatalf("discoverFiles failed: %v", err)
+	}
+	if len(files) != 1 || len(excluded) != 0 {
+		t.Errorf("Expected the cache to be included, but got %v (excluded %v)", files, excluded)
+	}
+}
+
+// TestGenerateBlockContents tests removing moved blocks from generate heredocs
+func TestGenerateBlockContents(t *testing.T) {
+	tempDir := t.TempDir()
+	file := filepath.Join(tempDir, "terragrunt.hcl")
+	content := `generate "moves" {
+  path      = "moves.tf"
+  if_exists = "overwrite"
+  contents  = <<EOF
+resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+EOF
+}
+
+generate "indented" {
+  path     = "indented.tf"
+  contents = <<-EOF
+    moved {
+      from = aws_s3_bucket.a
+      to   = aws_s3_bucket.b
+    }
+
+    output "x" {
+      value = "${local.x}"
+    }
+  EOF
+}
+
+generate "templated" {
+  path     = "templated.tf"
+  contents = <<EOF
+%{ for name in local.names }
+moved {
+  from = aws_instance.${name}
+  to   = aws_instance.new_${name}
+}
+%{ endfor }
+EOF
+}
+`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	stats := Stats{}
+	if err := processFile(file, &stats); err != nil {
+		t.Fatalf("processFile failed: %v", err)
+	}
+	if stats.MovedBlocksRemoved != 2 {
+		t.Errorf("Expected MovedBlocksRemoved to be 2, but got %d", stats.MovedBlocksRemoved)
+	}
+
+	modified, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed to read modified file: %v", err)
+	}
+	result := string(modified)
+	t.Logf("Modified content:\n%s", result)
+
+	expectedMoves := `  contents  = <<EOF
+resource "aws_instance" "web" {}
+EOF
+`
+	if !strings.Contains(result, expectedMoves) {
+		t.Errorf("Expected moved block to be removed from generate \"moves\"")
+	}
+	expectedIndented := `  contents = <<-EOF
+    output "x" {
+      value = "${local.x}"
+    }
+  EOF
+`
+	if !strings.Contains(result, expectedIndented) {
+		t.Errorf("Expected moved block to be removed from generate \"indented\"")
+	}
+	if !strings.Contains(result, "aws_instance.new_${name}") {
+		t.Errorf("Expected templated contents to be left unchanged")
+	}
+	if len(stats.Diagnostics) != 1 {
+		t.Errorf("Expected a warning for the templated contents, but got %d diagnostics", len(stats.Diagnostics))
+	}
+}
+
+// TestTerragruntOtherHCLFiles tests that .hcl files Terragrunt does not read,
+// such as Packer templates and the configuration file, are left alone
+func TestTerragruntOtherHCLFiles(t *testing.T) {
+	rootDir := t.TempDir()
+	packer := "source \"null\" \"x\" {\n  communicator   =   \"none\"\n}\n"
+	config := "keep   \"all\" {\n  when = false\n}\n"
+	writeTestFiles(t, rootDir, map[string]string{
+		"terragrunt.hcl":     "inputs = {\n  name   = \"app\"\n}\n",
+		"build.pkr.hcl":      packer,
+		".moved-remover.hcl": config,
+	})
+
+	files, _, err := discoverFiles(rootDir, discoveryOptions{Terragrunt: true})
+	if err != nil {
+		t.Fatalf("discoverFiles failed: %v", err)
+	}
+	stats := Stats{}
+	processFiles(files, &stats)
+	if stats.FilesModified != 1 {
+		t.Errorf("Expected only terragrunt.hcl to be modified, but got %d files modified", stats.FilesModified)
+	}
+
+	for name, original := range map[string]string{"build.pkr.hcl": packer, ".moved-remover.hcl": config} {
+		content, err := os.ReadFile(filepath.Join(rootDir, name))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", name, err)
+		}
+		if string(content) != original {
+			t.Errorf("Expected %s to be unchanged, but got:\n%s", name, content)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultVerifyTimeout bounds how long the verify command may run in a module
+const defaultVerifyTimeout = 10 * time.Minute
+
+// verifyResult records the verification of one root module directory
+type verifyResult struct {
+	Dir    string
+	Err    error
+	Output []byte
+	// RolledBack lists the files restored to their pre-run content because
+	// verification failed, in the root module and the modules it calls
+	RolledBack []string
+}
+
+// newVerifyCommand splits a verify command such as
+// "terraform plan -detailed-exitcode" into its arguments
+func newVerifyCommand(command string) ([]string, error) {
+	return splitCommand("verify", command)
+}
+
+// splitCommand splits a command given on the command line into its
+// arguments. The command is run without a shell, so it must be a single
+// simple command. kind names the command in errors.
+func splitCommand(kind, command string) ([]string, error) {
+	commands, err := parseShellScript(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s command: %w", kind, err)
+	}
+	if len(commands) != 1 {
+		return nil, fmt.Errorf("invalid %s command %q: expected a single command", kind, command)
+	}
+	if commands[0].Expanded {
+		return nil, fmt.Errorf("invalid %s command %q: variable and command expansion is not supported", kind, command)
+	}
+	return commands[0].Args, nil
+}
+
+// verifyModules runs the verify command in every root module that reaches a
+// modified file through local module calls in graph, since a child module
+// cannot be planned on its own. Without a graph, the command runs in each
+// directory with a modified file. When it fails in a root module, the
+// modified files of that root and of the modules it calls are restored from
+// stats.Originals and the run's statistics are adjusted. Any exit status
+// other than 0 is a failure, so terraform plan -detailed-exitcode fails when
+// removing the moved blocks changes the plan.
+func verifyModules(args []string, timeout time.Duration, graph *reconciler, stats *Stats) []verifyResult {
+	roots := make(map[string][]string)
+	for _, file := range stats.Files {
+		if !file.changed() {
+			continue
+		}
+		dirs := []string{filepath.Dir(file.Path)}
+		if graph != nil {
+			dirs = graph.rootsOf(dirs[0])
+		}
+		for _, dir := range dirs {
+			roots[dir] = append(roots[dir], file.Path)
+		}
+	}
+	dirs := make([]string, 0, len(roots))
+	for dir := range roots {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	// A module shared by several roots is rolled back once, when the first
+	// of them fails
+	rolledBack := make(map[string]bool)
+	var results []verifyResult
+	for _, dir := range dirs {
+		result := verifyResult{Dir: dir}
+		result.Output, result.Err = runVerifyCommand(args, dir, timeout)
+		if result.Err != nil {
+			for _, file := range roots[dir] {
+				if rolledBack[file] {
+					continue
+				}
+				if err := stats.rollback(file); err != nil {
+					result.Err = fmt.Errorf("%w; %s", result.Err, err)
+					continue
+				}
+				rolledBack[file] = true
+				result.RolledBack = append(result.RolledBack, file)
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// runVerifyCommand runs the verify command in dir and returns its combined
+// output
+func runVerifyCommand(args []string, dir string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v", timeout)
+	}
+	return output.Bytes(), err
+}
+
+// rollback restores a modified file to the content it had before the run and
+// removes its changes from the statistics, the journal and the removed blocks
+func (s *Stats) rollback(filePath string) error {
+	original, ok := s.Originals[filePath]
+	if !ok {
+		return fmt.Errorf("no pre-run content recorded for %s", filePath)
+	}
+	if err := os.WriteFile(filePath, original, 0644); err != nil {
+		return fmt.Errorf("error restoring file %s: %w", filePath, err)
+	}
+
+	for i := range s.Files {
+		file := &s.Files[i]
+		if file.Path != filePath {
+			continue
+		}
+		s.FilesModified--
+		s.MovedBlocksRemoved -= file.BlocksRemoved
+		if file.Status == FileDeleted {
+			deleted := s.DeletedFiles[:0]
+			for _, path := range s.DeletedFiles {
+				if path != filePath {
+					deleted = append(deleted, path)
+				}
+			}
+			s.DeletedFiles = deleted
+		}
+		file.Status = FileRolledBack
+		file.BlocksRemoved = 0
+		file.BytesAfter = file.BytesBefore
+	}
+
+	removed := s.RemovedBlocks[:0]
+	for _, block := range s.RemovedBlocks {
+		if block.Path != filePath {
+			removed = append(removed, block)
+		}
+	}
+	s.RemovedBlocks = removed
+
+	if s.Journal != nil {
+		s.Journal.forget(filePath)
+	}
+	return nil
+}
+
+// printVerifyResults prints the outcome of verification for each module
+func printVerifyResults(results []verifyResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	fmt.Printf("\nVerification:\n")
+	for _, result := range results {
+		if result.Err == nil {
+			fmt.Printf("  passed: %s\n", result.Dir)
+			continue
+		}
+		fmt.Printf("  failed: %s (%s), rolled back %d files\n", result.Dir, result.Err, len(result.RolledBack))
+		for _, line := range bytes.Split(bytes.TrimRight(result.Output, "\n"), []byte("\n")) {
+			if len(line) > 0 {
+				fmt.Printf("    %s\n", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/mkusaka/terraform-moved-remover/remover"
+)
+
+// defaultWatchDebounce is how long watch mode waits for changes to settle
+// before processing them
+const defaultWatchDebounce = 300 * time.Millisecond
+
+// watcher re-runs processFile on files as they change and reports which
+// moved blocks are still outstanding
+type watcher struct {
+	rootDir  string
+	opts     discoveryOptions
+	debounce time.Duration
+	out      io.Writer
+
+	// base holds the options every batch of changes is processed with
+	base Stats
+	// written holds the hash of the content we last wrote to each file, so
+	// that the events caused by our own writes are ignored
+	written map[string]string
+	// outstanding holds the number of moved blocks left in each file
+	outstanding map[string]int
+	// discovered holds the files found by discovery, which includes files
+	// that are processed only because a Terragrunt configuration reads them
+	discovered map[string]bool
+}
+
+// newWatcher creates a watcher for rootDir. Files are processed with the
+// options in base.
+func newWatcher(rootDir string, opts discoveryOptions, base Stats, out io.Writer) *watcher {
+	return &watcher{
+		rootDir:     rootDir,
+		opts:        opts,
+		debounce:    defaultWatchDebounce,
+		out:         out,
+		base:        base,
+		written:     make(map[string]string),
+		outstanding: make(map[string]int),
+		discovered:  make(map[string]bool),
+	}
+}
+
+// skipDir reports whether a directory is not watched
+func (w *watcher) skipDir(path string, skip map[string]bool) bool {
+	name := filepath.Base(path)
+	if name == ".git" || name == journalDir || (name == terragruntCacheDir && !w.opts.IncludeTerragruntCache) {
+		return path != w.rootDir
+	}
+	abs, err := filepath.Abs(path)
+	return err == nil && skip[abs]
+}
+
+// addDirs watches dir and every directory below it
+func (w *watcher) addDirs(fsw *fsnotify.Watcher, dir string) error {
+	skip := make(map[string]bool)
+	for _, excluded := range w.opts.ExcludeDirs {
+		if abs, err := filepath.Abs(excluded); err == nil {
+			skip[abs] = true
+		}
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("error accessing path %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if w.skipDir(path, skip) {
+			return filepath.SkipDir
+		}
+		if err := fsw.Add(path); err != nil {
+			return fmt.Errorf("error watching %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// run processes every file once and then processes changed files until ctx
+// is done
+func (w *watcher) run(ctx context.Context) error {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error starting watcher: %w", err)
+	}
+	defer fsw.Close()
+
+	if err := w.addDirs(fsw, w.rootDir); err != nil {
+		return err
+	}
+
+	files, _, err := discoverFiles(w.rootDir, w.opts)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		w.discovered[file] = true
+	}
+	w.process(files)
+	fmt.Fprintf(w.out, "Watching %s for changes (press Ctrl+C to stop)\n", w.rootDir)
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-fsw.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.addDirs(fsw, event.Name); err != nil {
+						fmt.Fprintf(w.out, "Error: %s\n", err)
+					}
+					// Files may have been written before the directory was
+					// watched
+					files, _, err := discoverFiles(event.Name, w.opts)
+					if err != nil {
+						fmt.Fprintf(w.out, "Error: %s\n", err)
+					}
+					for _, file := range files {
+						w.discovered[file] = true
+						pending[file] = true
+					}
+					if len(files) > 0 {
+						timer.Reset(w.debounce)
+					}
+					continue
+				}
+			}
+			if !w.opts.includes(event.Name) && !w.discovered[event.Name] {
+				continue
+			}
+			pending[event.Name] = true
+			timer.Reset(w.debounce)
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(w.out, "Error: %s\n", err)
+
+		case <-timer.C:
+			files := make([]string, 0, len(pending))
+			for file := range pending {
+				files = append(files, file)
+			}
+			sort.Strings(files)
+			pending = make(map[string]bool)
+			w.process(w.changed(files))
+		}
+	}
+}
+
+// changed filters out files whose content is what we wrote to them last,
+// and forgets files that were removed
+func (w *watcher) changed(files []string) []string {
+	var changed []string
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			delete(w.written, file)
+			if _, ok := w.outstanding[file]; ok {
+				delete(w.outstanding, file)
+				fmt.Fprintf(w.out, "[%s] %s: deleted\n", time.Now().Format("15:04:05"), file)
+			}
+			continue
+		}
+		if err == nil && w.written[file] == contentHash(content) {
+			continue
+		}
+		changed = append(changed, file)
+	}
+	return changed
+}
+
+// process runs processFile on files and prints a line for each of them,
+// followed by the moved blocks still outstanding in the watched tree
+func (w *watcher) process(files []string) {
+	if len(files) == 0 {
+		return
+	}
+
+	stats := w.base
+	// Diagnostics are printed with the file they belong to, so each batch
+	// starts without the ones of earlier batches
+	stats.Diagnostics = nil
+	stats.Sources = nil
+	now := time.Now().Format("15:04:05")
+	for _, file := range files {
+		removedBefore := stats.MovedBlocksRemoved
+		keptBefore := len(stats.KeptBlocks)
+		deletedBefore := len(stats.DeletedFiles)
+		diagsBefore := len(stats.Diagnostics)
+		err := processFile(file, &stats)
+		var diagErr *remover.DiagnosticsError
+		switch {
+		case errors.As(err, &diagErr):
+			fmt.Fprintf(w.out, "[%s] %s: error:\n", now, file)
+			w.writeDiagnostics(&stats, diagsBefore)
+			continue
+		case err != nil:
+			fmt.Fprintf(w.out, "[%s] %s: error: %s\n", now, file, err)
+			continue
+		}
+		w.writeDiagnostics(&stats, diagsBefore)
+		removed := stats.MovedBlocksRemoved - removedBefore
+		kept := len(stats.KeptBlocks) - keptBefore
+
+		if !stats.DryRun {
+			if content, err := os.ReadFile(file); err == nil {
+				w.written[file] = contentHash(content)
+			}
+		}
+
+		if len(stats.DeletedFiles) > deletedBefore && !stats.DryRun {
+			// Forget the file now, so that its removal is not reported again
+			delete(w.written, file)
+			delete(w.outstanding, file)
+			fmt.Fprintf(w.out, "[%s] %s: removed %d moved blocks and deleted the empty file\n", now, file, removed)
+			continue
+		}
+
+		w.outstanding[file] = kept
+		switch {
+		case stats.DryRun && removed > 0:
+			w.outstanding[file] += removed
+			fmt.Fprintf(w.out, "[%s] %s: %d moved blocks outstanding\n", now, file, removed)
+		case removed > 0:
+			fmt.Fprintf(w.out, "[%s] %s: removed %d moved blocks\n", now, file, removed)
+		case stats.Verbose:
+			fmt.Fprintf(w.out, "[%s] %s: no moved blocks\n", now, file)
+		}
+		for _, block := range stats.KeptBlocks[keptBefore:] {
+			fmt.Fprintf(w.out, "[%s] %s: kept %s -> %s (%s)\n", now, file, block.From, block.To, block.Reason)
+		}
+	}
+
+	total, inFiles := 0, 0
+	for _, count := range w.outstanding {
+		if count > 0 {
+			total += count
+			inFiles++
+		}
+	}
+	fmt.Fprintf(w.out, "[%s] Outstanding moved blocks: %d in %d files\n", now, total, inFiles)
+}
+
+// writeDiagnostics prints the diagnostics added since the first from, with
+// source snippets, in color when writing to a terminal
+func (w *watcher) writeDiagnostics(stats *Stats, from int) {
+	if len(stats.Diagnostics) <= from {
+		return
+	}
+	color := false
+	if f, ok := w.out.(*os.File); ok {
+		color = isTerminal(f)
+	}
+	_ = writeDiagnostics(w.out, stats.Sources, stats.Diagnostics[from:], color)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that can be written by the watcher while the
+// test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// waitFor polls until cond holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return cond()
+}
+
+const watchTestMoved = `resource "aws_instance" "web" {}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+
+// TestWatch tests that changed files are processed once, without reacting
+// to the watcher's own writes
+func TestWatch(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"main.tf": "resource \"aws_vpc\" \"main\" {}\n",
+	})
+
+	out := &syncBuffer{}
+	w := newWatcher(rootDir, discoveryOptions{}, Stats{}, out)
+	w.debounce = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("run failed: %v", err)
+		}
+	}()
+
+	if !waitFor(t, 5*time.Second, func() bool { return strings.Contains(out.String(), "Watching") }) {
+		t.Fatalf("Watcher did not start:\n%s", out.String())
+	}
+
+	// A new file in a new directory is picked up and cleaned
+	file := filepath.Join(rootDir, "modules", "app", "main.tf")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(file, []byte(watchTestMoved), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	removedLine := file + ": removed 1 moved blocks"
+	if !waitFor(t, 5*time.Second, func() bool { return strings.Contains(out.String(), removedLine) }) {
+		t.Fatalf("Expected the new file to be processed:\n%s", out.String())
+	}
+	content, _ := os.ReadFile(file)
+	if strings.Contains(string(content), "moved {") {
+		t.Errorf("Expected the moved block to be removed, but got:\n%s", content)
+	}
+
+	// Our own write must not trigger another run
+	time.Sleep(300 * time.Millisecond)
+	if n := strings.Count(out.String(), file+":"); n != 1 {
+		t.Errorf("Expected the file to be processed once, but it was processed %d times:\n%s", n, out.String())
+	}
+}
+
+// TestWatchOutstanding tests reporting outstanding moves in dry run mode
+func TestWatchOutstanding(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"a.tf": watchTestMoved,
+		"b.tf": watchTestMoved,
+	})
+
+	out := &syncBuffer{}
+	w := newWatcher(rootDir, discoveryOptions{}, Stats{DryRun: true}, out)
+	w.process([]string{filepath.Join(rootDir, "a.tf"), filepath.Join(rootDir, "b.tf")})
+	if !strings.Contains(out.String(), "Outstanding moved blocks: 2 in 2 files") {
+		t.Errorf("Expected 2 outstanding moves, but got:\n%s", out.String())
+	}
+
+	// Resolving the moves in one file updates the total
+	if err := os.WriteFile(filepath.Join(rootDir, "b.tf"), []byte("resource \"aws_instance\" \"web\" {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	w.process(w.changed([]string{filepath.Join(rootDir, "b.tf")}))
+	if !strings.Contains(out.String(), "Outstanding moved blocks: 1 in 1 files") {
+		t.Errorf("Expected 1 outstanding move, but got:\n%s", out.String())
+	}
+	content, _ := os.ReadFile(filepath.Join(rootDir, "a.tf"))
+	if string(content) != watchTestMoved {
+		t.Errorf("Expected dry run to leave files unchanged")
+	}
+}
+
+// TestWatchDiagnostics tests that parse failures are shown with a source
+// snippet, once for each batch that processes the file
+func TestWatchDiagnostics(t *testing.T) {
+	rootDir := t.TempDir()
+	writeTestFiles(t, rootDir, map[string]string{
+		"bad.tf": "resource \"aws_instance\" \"web\" {\n",
+	})
+	file := filepath.Join(rootDir, "bad.tf")
+
+	out := &syncBuffer{}
+	w := newWatcher(rootDir, discoveryOptions{}, Stats{DryRun: true}, out)
+	w.process([]string{file})
+	output := out.String()
+	for _, expected := range []string{file + ": error:", "Error: Unclosed configuration block", "on " + file + " line 1:", "^"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected the output to contain %q, but got:\n%s", expected, output)
+		}
+	}
+
+	w.process([]string{file})
+	if n := strings.Count(out.String(), "Unclosed configuration block"); n != 2 {
+		t.Errorf("Expected the diagnostic once per batch, but got it %d times:\n%s", n, out.String())
+	}
+}
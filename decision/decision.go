@@ -0,0 +1,88 @@
+// Package decision defines the hook that decides whether a moved block may
+// be removed.
+//
+// terraform-moved-remover asks every configured Decider about each moved
+// block it would remove. A block is kept as soon as one of them says so. A
+// Decider can be compiled into a program that runs the tool's engine, the
+// remover package, or run as an external plugin executable with Serve, which
+// the command line tool starts with -decision-plugin and talks to over stdin
+// and stdout.
+package decision
+
+// Position is a position in a file, with 1-based lines and columns and a
+// 0-based byte offset
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// Range is the source range of a block or expression
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// ModuleStep is one module call in an address, such as module.db["a"]
+type ModuleStep struct {
+	Name string `json:"name"`
+	// Key is the normalized instance key, such as [0] or ["a"], or empty
+	Key string `json:"key,omitempty"`
+}
+
+// Address is a parsed Terraform address. When Type is empty it refers to
+// the last module call in Module, and otherwise to a resource inside the
+// module calls in Module.
+type Address struct {
+	Module []ModuleStep `json:"module,omitempty"`
+	Mode   string       `json:"mode,omitempty"` // "managed" or "data"
+	Type   string       `json:"type,omitempty"`
+	Name   string       `json:"name,omitempty"`
+	Key    string       `json:"key,omitempty"`
+}
+
+// Block is a moved block the tool would remove. Ranges are zero for blocks
+// inside Terragrunt generate contents, whose positions are not tracked.
+type Block struct {
+	Path      string `json:"path"`
+	Range     Range  `json:"range"`
+	FromRange Range  `json:"from_range"`
+	ToRange   Range  `json:"to_range"`
+	// From and To are the source text of the addresses
+	From string `json:"from"`
+	To   string `json:"to"`
+	// FromAddress and ToAddress are nil when the address cannot be parsed
+	FromAddress *Address `json:"from_address,omitempty"`
+	ToAddress   *Address `json:"to_address,omitempty"`
+	// Comments is the text of the comments directly above the block
+	Comments string `json:"comments,omitempty"`
+}
+
+// Action is what a Decider wants done with a block
+type Action string
+
+const (
+	Remove Action = "remove"
+	Keep   Action = "keep"
+)
+
+// Result is the decision for one block. Reason is shown to the user for
+// kept blocks.
+type Result struct {
+	Action Action `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Decider decides whether a moved block may be removed. An error fails the
+// file the block is in, which is then left unchanged.
+type Decider interface {
+	Decide(block Block) (Result, error)
+}
+
+// DeciderFunc adapts a function to the Decider interface
+type DeciderFunc func(block Block) (Result, error)
+
+// Decide calls f(block)
+func (f DeciderFunc) Decide(block Block) (Result, error) {
+	return f(block)
+}
@@ -0,0 +1,150 @@
+package decision
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// ProtocolVersion is the version of the plugin protocol. The tool sends one
+// Request per line on the plugin's stdin and reads one Response per line
+// from its stdout. The plugin is started once per run and exits when its
+// stdin is closed. Anything it writes to stderr is passed through.
+const ProtocolVersion = 1
+
+// Request is sent to a plugin for every block
+type Request struct {
+	Version int   `json:"version"`
+	Block   Block `json:"block"`
+}
+
+// Response is a plugin's answer to a Request. Error is set when the plugin
+// could not decide.
+type Response struct {
+	Action Action `json:"action,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Serve runs d as a plugin, answering requests read from r until it reaches
+// the end of its input. A plugin's main function is usually just:
+//
+//	if err := decision.Serve(myDecider, os.Stdin, os.Stdout); err != nil {
+//		log.Fatal(err)
+//	}
+func Serve(d Decider, r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	enc := json.NewEncoder(w)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			if err := enc.Encode(serveRequest(d, line)); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// serveRequest decodes a request and asks d about its block
+func serveRequest(d Decider, line []byte) Response {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return Response{Error: fmt.Sprintf("invalid request: %s", err)}
+	}
+	if req.Version != ProtocolVersion {
+		return Response{Error: fmt.Sprintf("unsupported protocol version %d", req.Version)}
+	}
+	result, err := d.Decide(req.Block)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{Action: result.Action, Reason: result.Reason}
+}
+
+// Plugin is a Decider backed by an external executable that speaks the
+// plugin protocol
+type Plugin struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+
+	mu sync.Mutex
+}
+
+// StartPlugin starts a plugin executable with the given arguments
+func StartPlugin(args []string) (*Plugin, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no plugin command given")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting plugin %s: %w", args[0], err)
+	}
+	return &Plugin{name: args[0], cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+// Decide sends the block to the plugin and waits for its answer
+func (p *Plugin) Decide(block Block) (Result, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req, err := json.Marshal(Request{Version: ProtocolVersion, Block: block})
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := p.stdin.Write(append(req, '\n')); err != nil {
+		return Result{}, fmt.Errorf("plugin %s: %w", p.name, err)
+	}
+	line, err := p.stdout.ReadBytes('\n')
+	if err != nil {
+		return Result{}, fmt.Errorf("plugin %s did not answer: %w", p.name, err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return Result{}, fmt.Errorf("plugin %s sent an invalid response: %w", p.name, err)
+	}
+	if resp.Error != "" {
+		return Result{}, fmt.Errorf("plugin %s: %s", p.name, resp.Error)
+	}
+	if resp.Action != Keep && resp.Action != Remove {
+		return Result{}, fmt.Errorf("plugin %s sent unknown action %q", p.name, resp.Action)
+	}
+	return Result{Action: resp.Action, Reason: resp.Reason}, nil
+}
+
+// Close closes the plugin's stdin and waits for it to exit
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.stdin.Close(); err != nil {
+		return err
+	}
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("plugin %s: %w", p.name, err)
+	}
+	return nil
+}
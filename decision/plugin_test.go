@@ -0,0 +1,88 @@
+package decision
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// prodPolicy keeps moves of modules whose name contains prod
+var prodPolicy = DeciderFunc(func(block Block) (Result, error) {
+	if block.FromAddress == nil {
+		return Result{}, errors.New("unparsed address")
+	}
+	for _, step := range block.FromAddress.Module {
+		if strings.Contains(step.Name, "prod") {
+			return Result{Action: Keep, Reason: "touches a prod module"}, nil
+		}
+	}
+	return Result{Action: Remove}, nil
+})
+
+// TestMain runs the test binary as a plugin when asked to, so that
+// TestPlugin can start it
+func TestMain(m *testing.M) {
+	if os.Getenv("DECISION_TEST_PLUGIN") == "1" {
+		if err := Serve(prodPolicy, os.Stdin, os.Stdout); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestServe tests answering requests, including invalid ones
+func TestServe(t *testing.T) {
+	input := `{"version": 1, "block": {"path": "main.tf", "from": "module.prod_db", "to": "module.db", "from_address": {"module": [{"name": "prod_db"}]}}}
+
+{"version": 2, "block": {}}
+not json
+{"version": 1, "block": {"path": "main.tf", "from": "x"}}`
+	var out bytes.Buffer
+	if err := Serve(prodPolicy, strings.NewReader(input), &out); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{
+		`{"action":"keep","reason":"touches a prod module"}`,
+		`{"error":"unsupported protocol version 2"}`,
+		`{"error":"invalid request: invalid character 'o' in literal null (expecting 'u')"}`,
+		`{"error":"unparsed address"}`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d responses, but got %d:\n%s", len(expected), len(lines), out.String())
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Expected response %d to be %s, but got %s", i+1, expected[i], lines[i])
+		}
+	}
+}
+
+// TestPlugin tests talking to a plugin executable
+func TestPlugin(t *testing.T) {
+	t.Setenv("DECISION_TEST_PLUGIN", "1")
+	plugin, err := StartPlugin([]string{os.Args[0]})
+	if err != nil {
+		t.Fatalf("StartPlugin failed: %v", err)
+	}
+
+	result, err := plugin.Decide(Block{From: "module.prod.aws_instance.a", FromAddress: &Address{Module: []ModuleStep{{Name: "prod"}}, Type: "aws_instance", Name: "a"}})
+	if err != nil || result != (Result{Action: Keep, Reason: "touches a prod module"}) {
+		t.Errorf("Expected the block to be kept, but got %+v, %v", result, err)
+	}
+	result, err = plugin.Decide(Block{From: "aws_instance.a", FromAddress: &Address{Type: "aws_instance", Name: "a"}})
+	if err != nil || result.Action != Remove {
+		t.Errorf("Expected the block to be removed, but got %+v, %v", result, err)
+	}
+	if _, err := plugin.Decide(Block{From: "x"}); err == nil || !strings.Contains(err.Error(), "unparsed address") {
+		t.Errorf("Expected the plugin's error, but got %v", err)
+	}
+
+	if err := plugin.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package remover
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ModuleStep is one module call in an address, such as module.db["a"]
+type ModuleStep struct {
+	Name string
+	// Key is the normalized instance key, such as [0] or ["a"], or empty
+	Key string
+}
+
+// Address is a parsed Terraform address as used in the from and to arguments
+// of moved blocks and in terraform state mv. It is either a module call, when
+// Type is empty, or a resource inside a module.
+type Address struct {
+	Module []ModuleStep
+	Mode   string // "managed" or "data" for resources, empty for module calls
+	Type   string
+	Name   string
+	// Key is the normalized resource instance key, or empty
+	Key string
+}
+
+// ParseAddress parses an address such as aws_instance.web["a"] or
+// module.x[0].aws_s3_bucket.b
+func ParseAddress(s string) (Address, error) {
+	traversal, diags := hclsyntax.ParseTraversalAbs([]byte(strings.TrimSpace(s)), "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return Address{}, fmt.Errorf("invalid address %q: %s", s, diags.Error())
+	}
+	return addressFromTraversal(s, traversal)
+}
+
+// addressFromTraversal interprets a parsed traversal as an address. s is
+// only used in error messages.
+func addressFromTraversal(s string, traversal hcl.Traversal) (Address, error) {
+	var addr Address
+
+	names := make([]string, 0, len(traversal))
+	keys := make([]string, 0, len(traversal))
+	for i, step := range traversal {
+		switch step := step.(type) {
+		case hcl.TraverseRoot:
+			names = append(names, step.Name)
+			keys = append(keys, "")
+		case hcl.TraverseAttr:
+			names = append(names, step.Name)
+			keys = append(keys, "")
+		case hcl.TraverseIndex:
+			if i == 0 || keys[len(keys)-1] != "" {
+				return Address{}, fmt.Errorf("invalid address %q: unexpected index", s)
+			}
+			key, err := InstanceKey(step.Key)
+			if err != nil {
+				return Address{}, fmt.Errorf("invalid address %q: %w", s, err)
+			}
+			keys[len(keys)-1] = key
+		default:
+			return Address{}, fmt.Errorf("invalid address %q: unsupported traversal", s)
+		}
+	}
+
+	i := 0
+	for i < len(names) && names[i] == "module" {
+		if keys[i] != "" || i+1 >= len(names) {
+			return Address{}, fmt.Errorf("invalid address %q: module call name expected", s)
+		}
+		addr.Module = append(addr.Module, ModuleStep{Name: names[i+1], Key: keys[i+1]})
+		i += 2
+	}
+
+	rest := names[i:]
+	if len(rest) == 0 {
+		if len(addr.Module) == 0 {
+			return Address{}, fmt.Errorf("invalid address %q: empty address", s)
+		}
+		return addr, nil
+	}
+
+	addr.Mode = "managed"
+	if rest[0] == "data" {
+		addr.Mode = "data"
+		rest = rest[1:]
+		i++
+	}
+	if len(rest) != 2 || keys[i] != "" {
+		return Address{}, fmt.Errorf("invalid address %q: resource type and name expected", s)
+	}
+	addr.Type = rest[0]
+	addr.Name = rest[1]
+	addr.Key = keys[i+1]
+	return addr, nil
+}
+
+// InstanceKey renders a count or for_each key in its normalized form
+func InstanceKey(key cty.Value) (string, error) {
+	if key.IsNull() || !key.IsKnown() {
+		return "", fmt.Errorf("invalid instance key")
+	}
+	switch key.Type() {
+	case cty.Number:
+		bf := key.AsBigFloat()
+		if !bf.IsInt() {
+			return "", fmt.Errorf("instance key %s is not an integer", bf.Text('f', -1))
+		}
+		return "[" + bf.Text('f', -1) + "]", nil
+	case cty.String:
+		return "[" + string(hclwrite.TokensForValue(key).Bytes()) + "]", nil
+	}
+	return "", fmt.Errorf("instance key must be a number or a string")
+}
+
+// IsModule reports whether the address refers to a module call
+func (a Address) IsModule() bool {
+	return a.Type == ""
+}
+
+// ModulePath renders the module part of the address, such as
+// module.x[0].module.y, or an empty string for the root module
+func (a Address) ModulePath() string {
+	parts := make([]string, 0, len(a.Module))
+	for _, step := range a.Module {
+		parts = append(parts, "module."+step.Name+step.Key)
+	}
+	return strings.Join(parts, ".")
+}
+
+// Resource renders the resource part of the address without its module path
+func (a Address) Resource() string {
+	if a.IsModule() {
+		return ""
+	}
+	prefix := ""
+	if a.Mode == "data" {
+		prefix = "data."
+	}
+	return prefix + a.Type + "." + a.Name + a.Key
+}
+
+// String renders the address in its normalized form, so that addresses that
+// differ only in formatting compare equal
+func (a Address) String() string {
+	module := a.ModulePath()
+	resource := a.Resource()
+	switch {
+	case module == "":
+		return resource
+	case resource == "":
+		return module
+	}
+	return module + "." + resource
+}
+
+// Equal reports whether two addresses refer to the same object
+func (a Address) Equal(other Address) bool {
+	return a.String() == other.String()
+}
+
+// Contains reports whether other is a itself or an object within a. For
+// example aws_instance.web contains aws_instance.web[0], and module.x
+// contains module.x[0].aws_s3_bucket.b.
+func (a Address) Contains(other Address) bool {
+	if len(other.Module) < len(a.Module) {
+		return false
+	}
+	for i, step := range a.Module {
+		o := other.Module[i]
+		if step.Name != o.Name {
+			return false
+		}
+		// The last module step of a module address without a key matches
+		// every instance of that call
+		last := a.IsModule() && i == len(a.Module)-1
+		if step.Key != o.Key && !(last && step.Key == "") {
+			return false
+		}
+	}
+	if a.IsModule() {
+		return true
+	}
+	if len(other.Module) != len(a.Module) || other.IsModule() {
+		return false
+	}
+	return a.Mode == other.Mode && a.Type == other.Type && a.Name == other.Name &&
+		(a.Key == other.Key || a.Key == "")
+}
+
+// NormalizeAddress returns the normalized form of an address, or the trimmed
+// text if it cannot be parsed
+func NormalizeAddress(s string) string {
+	addr, err := ParseAddress(s)
+	if err != nil {
+		return strings.TrimSpace(s)
+	}
+	return addr.String()
+}
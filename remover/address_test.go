@@ -0,0 +1,76 @@
+package remover
+
+import "testing"
+
+// TestParseAddress tests parsing and normalizing moved block addresses
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		module   string
+		resource string
+	}{
+		{`aws_instance.web`, `aws_instance.web`, ``, `aws_instance.web`},
+		{`aws_instance.web[ "a" ]`, `aws_instance.web["a"]`, ``, `aws_instance.web["a"]`},
+		{`aws_instance.web[0]`, `aws_instance.web[0]`, ``, `aws_instance.web[0]`},
+		{`data.aws_ami.ubuntu`, `data.aws_ami.ubuntu`, ``, `data.aws_ami.ubuntu`},
+		{`module.x[0].aws_s3_bucket.b`, `module.x[0].aws_s3_bucket.b`, `module.x[0]`, `aws_s3_bucket.b`},
+		{`module.a.module.b["k.1"]`, `module.a.module.b["k.1"]`, `module.a.module.b["k.1"]`, ``},
+		{` module.a [ "x" ] . aws_instance.web `, `module.a["x"].aws_instance.web`, `module.a["x"]`, `aws_instance.web`},
+	}
+
+	for _, tt := range tests {
+		addr, err := ParseAddress(tt.input)
+		if err != nil {
+			t.Errorf("ParseAddress(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if addr.String() != tt.expected {
+			t.Errorf("ParseAddress(%q) = %q, expected %q", tt.input, addr.String(), tt.expected)
+		}
+		if addr.ModulePath() != tt.module {
+			t.Errorf("ParseAddress(%q).ModulePath() = %q, expected %q", tt.input, addr.ModulePath(), tt.module)
+		}
+		if addr.Resource() != tt.resource {
+			t.Errorf("ParseAddress(%q).Resource() = %q, expected %q", tt.input, addr.Resource(), tt.resource)
+		}
+	}
+
+	for _, invalid := range []string{``, `aws_instance`, `aws_instance.web.extra`, `module`, `module[0].x`, `aws_instance.web[0][1]`, `aws_instance.web[1.5]`, `"quoted"`} {
+		if _, err := ParseAddress(invalid); err == nil {
+			t.Errorf("Expected ParseAddress(%q) to fail", invalid)
+		}
+	}
+}
+
+// TestAddressContains tests matching instances against moved addresses
+func TestAddressContains(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{`aws_instance.web`, `aws_instance.web[0]`, true},
+		{`aws_instance.web["a"]`, `aws_instance.web[ "a" ]`, true},
+		{`aws_instance.web[0]`, `aws_instance.web`, false},
+		{`aws_instance.web`, `aws_instance.app`, false},
+		{`module.x`, `module.x[0].aws_s3_bucket.b`, true},
+		{`module.x`, `module.x.module.y.aws_s3_bucket.b`, true},
+		{`module.x[1]`, `module.x[0].aws_s3_bucket.b`, false},
+		{`module.x.aws_s3_bucket.b`, `module.x[0].aws_s3_bucket.b`, false},
+		{`aws_s3_bucket.b`, `module.x.aws_s3_bucket.b`, false},
+	}
+
+	for _, tt := range tests {
+		a, err := ParseAddress(tt.a)
+		if err != nil {
+			t.Fatalf("ParseAddress(%q) failed: %v", tt.a, err)
+		}
+		b, err := ParseAddress(tt.b)
+		if err != nil {
+			t.Fatalf("ParseAddress(%q) failed: %v", tt.b, err)
+		}
+		if got := a.Contains(b); got != tt.expected {
+			t.Errorf("%s.Contains(%s) = %v, expected %v", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package remover
+
+import (
+	"bytes"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// RemoveBlocks removes top-level blocks from a file and returns the
+// resulting source. Each block is cut out of the token stream together with
+// its leading comments, and only the blank lines where it was are adjusted,
+// so the result looks as if the block had never been there:
+//
+//   - a block at the start of the file takes the blank lines after it along
+//   - a block at the end of the file takes the blank lines before it along
+//   - between two items, the larger of the two gaps around it is kept
+//
+// Blank lines elsewhere in the file are left alone.
+func RemoveBlocks(file *hclwrite.File, blocks []*hclwrite.Block) []byte {
+	tokens := file.BuildTokens(nil)
+	for _, block := range blocks {
+		blockTokens := block.BuildTokens(nil)
+		if len(blockTokens) == 0 {
+			continue
+		}
+		start := tokenIndex(tokens, blockTokens[0])
+		if start < 0 {
+			continue
+		}
+		tokens = cutTokens(tokens, start, start+len(blockTokens))
+	}
+	return tokens.Bytes()
+}
+
+// tokenIndex returns the position of token in tokens, or -1
+func tokenIndex(tokens hclwrite.Tokens, token *hclwrite.Token) int {
+	for i, t := range tokens {
+		if t == token {
+			return i
+		}
+	}
+	return -1
+}
+
+// endsLine reports whether a token ends its line. Comments include their
+// newline, other tokens are followed by a newline token.
+func endsLine(token *hclwrite.Token) bool {
+	return token.Type == hclsyntax.TokenNewline || bytes.HasSuffix(token.Bytes, []byte("\n"))
+}
+
+// cutTokens removes tokens[start:end], which is a whole item of a body, and
+// merges the blank lines on either side of it
+func cutTokens(tokens hclwrite.Tokens, start, end int) hclwrite.Tokens {
+	// Blank lines before the item. The first newline after a token that
+	// does not end its line is that line's end, not a blank line.
+	before := start
+	for before > 0 && tokens[before-1].Type == hclsyntax.TokenNewline {
+		before--
+	}
+	if before > 0 && before < start && !endsLine(tokens[before-1]) {
+		before++
+	}
+
+	// Blank lines after the item, likewise
+	after := end
+	if end > start && !endsLine(tokens[end-1]) && after < len(tokens) && tokens[after].Type == hclsyntax.TokenNewline {
+		after++
+	}
+	blankAfter := after
+	for blankAfter < len(tokens) && tokens[blankAfter].Type == hclsyntax.TokenNewline {
+		blankAfter++
+	}
+
+	atStart := before == 0
+	atEnd := blankAfter == len(tokens) || tokens[blankAfter].Type == hclsyntax.TokenEOF
+	nBefore, nAfter := start-before, blankAfter-after
+
+	keep := nBefore
+	switch {
+	case atStart && atEnd:
+		keep = 0
+	case atStart:
+		keep = nBefore
+	case atEnd:
+		keep = nAfter
+	case nAfter > nBefore:
+		keep = nAfter
+	}
+
+	result := make(hclwrite.Tokens, 0, len(tokens)-(blankAfter-before)+keep)
+	result = append(result, tokens[:before]...)
+	for i := 0; i < keep; i++ {
+		result = append(result, &hclwrite.Token{Type: hclsyntax.TokenNewline, Bytes: []byte("\n")})
+	}
+	return append(result, tokens[blankAfter:]...)
+}
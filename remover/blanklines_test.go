@@ -0,0 +1,141 @@
+package remover
+
+import (
+	"testing"
+)
+
+// TestBlankLinesAroundRemovedBlocks tests that removing moved blocks leaves
+// the file as if they had never been there
+func TestBlankLinesAroundRemovedBlocks(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "between items",
+			input: `a = 1
+
+moved {
+  from = x.a
+  to   = x.b
+}
+
+b = 2
+`,
+			expected: "a = 1\n\nb = 2\n",
+		},
+		{
+			name: "grouped with the previous item",
+			input: `resource "x" "b" {}
+moved {
+  from = x.a
+  to   = x.b
+}
+
+b = 2
+`,
+			expected: "resource \"x\" \"b\" {}\n\nb = 2\n",
+		},
+		{
+			name: "grouped with the next item",
+			input: `a = 1
+
+
+moved {
+  from = x.a
+  to   = x.b
+}
+resource "x" "b" {}
+`,
+			expected: "a = 1\n\n\nresource \"x\" \"b\" {}\n",
+		},
+		{
+			name: "at the start with a leading comment",
+			input: `# Renamed in 2024
+moved {
+  from = x.a
+  to   = x.b
+}
+
+resource "x" "b" {}
+`,
+			expected: "resource \"x\" \"b\" {}\n",
+		},
+		{
+			name: "at the end",
+			input: `resource "x" "b" {}
+
+moved {
+  from = x.a
+  to   = x.b
+}
+`,
+			expected: "resource \"x\" \"b\" {}\n",
+		},
+		{
+			name: "consecutive blocks",
+			input: `a = 1
+
+moved {
+  from = x.a
+  to   = x.b
+}
+moved {
+  from = x.c
+  to   = x.d
+}
+
+moved {
+  from = x.e
+  to   = x.f
+}
+
+b = 2
+`,
+			expected: "a = 1\n\nb = 2\n",
+		},
+		{
+			name: "blank lines elsewhere are kept",
+			input: `a = 1
+
+
+
+b = 2
+
+moved {
+  from = x.a
+  to   = x.b
+}
+
+c = 3
+`,
+			expected: "a = 1\n\n\n\nb = 2\n\nc = 3\n",
+		},
+		{
+			name: "only moved blocks",
+			input: `
+moved {
+  from = x.a
+  to   = x.b
+}
+
+moved {
+  from = x.c
+  to   = x.d
+}
+`,
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		result, err := Remove("test.tf", []byte(tc.input), Options{})
+		if err != nil {
+			t.Fatalf("%s: Remove failed: %v", tc.name, err)
+		}
+		if string(result.Content) != tc.expected {
+			t.Errorf("%s: expected:\n%q\nActual:\n%q", tc.name, tc.expected, string(result.Content))
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package remover
+
+import (
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mkusaka/terraform-moved-remover/decision"
+)
+
+// decisionBlock describes a moved block to a decision.Decider
+func decisionBlock(filePath string, mb *MovedBlock) decision.Block {
+	block := decision.Block{
+		Path:      filePath,
+		Range:     decisionRange(mb.Range),
+		FromRange: decisionRange(mb.FromRange),
+		ToRange:   decisionRange(mb.ToRange),
+		From:      mb.From,
+		To:        mb.To,
+		Comments:  leadingComments(mb.Block),
+	}
+	if addr, err := ParseAddress(mb.From); err == nil {
+		block.FromAddress = addr.decisionAddress()
+	}
+	if addr, err := ParseAddress(mb.To); err == nil {
+		block.ToAddress = addr.decisionAddress()
+	}
+	return block
+}
+
+// leadingComments returns the text of the comments directly above a block,
+// which hclwrite includes in the block's tokens
+func leadingComments(block *hclwrite.Block) string {
+	if block == nil {
+		return ""
+	}
+	var comments strings.Builder
+	for _, token := range block.BuildTokens(nil) {
+		if token.Type != hclsyntax.TokenComment {
+			break
+		}
+		comments.Write(token.Bytes)
+	}
+	return strings.TrimRight(comments.String(), "\n")
+}
+
+// decisionRange converts a source range
+func decisionRange(r hcl.Range) decision.Range {
+	return decision.Range{
+		Start: decision.Position{Line: r.Start.Line, Column: r.Start.Column, Byte: r.Start.Byte},
+		End:   decision.Position{Line: r.End.Line, Column: r.End.Column, Byte: r.End.Byte},
+	}
+}
+
+// decisionAddress converts a parsed address
+func (a Address) decisionAddress() *decision.Address {
+	addr := &decision.Address{Mode: a.Mode, Type: a.Type, Name: a.Name, Key: a.Key}
+	for _, step := range a.Module {
+		addr.Module = append(addr.Module, decision.ModuleStep{Name: step.Name, Key: step.Key})
+	}
+	return addr
+}
@@ -0,0 +1,69 @@
+package remover
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mkusaka/terraform-moved-remover/decision"
+)
+
+const decideInput = `resource "aws_instance" "web" {}
+
+moved {
+  from = module.prod_db.aws_db_instance.main
+  to   = module.db.aws_db_instance.main
+}
+
+moved {
+  from = aws_instance.old
+  to   = aws_instance.web
+}
+`
+
+// TestDeciders tests that deciders can keep moved blocks and see where the
+// blocks are
+func TestDeciders(t *testing.T) {
+	var seen []decision.Block
+	keepProd := decision.DeciderFunc(func(block decision.Block) (decision.Result, error) {
+		seen = append(seen, block)
+		if block.FromAddress != nil && len(block.FromAddress.Module) > 0 && strings.HasPrefix(block.FromAddress.Module[0].Name, "prod") {
+			return decision.Result{Action: decision.Keep, Reason: "touches a prod module"}, nil
+		}
+		return decision.Result{Action: decision.Remove}, nil
+	})
+
+	result, err := Remove("main.tf", []byte(decideInput), Options{Deciders: []decision.Decider{keepProd}})
+	if err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	content := string(result.Content)
+	if len(result.Removed) != 1 || strings.Contains(content, "aws_instance.old") || !strings.Contains(content, "module.prod_db") {
+		t.Errorf("Expected only the second block to be removed, but got:\n%s", content)
+	}
+	if len(result.Kept) != 1 || result.Kept[0].Reason != "touches a prod module" {
+		t.Errorf("Expected the prod block to be kept with the decider's reason, but got %+v", result.Kept)
+	}
+
+	if len(seen) != 2 {
+		t.Fatalf("Expected the decider to be asked about 2 blocks, but got %d", len(seen))
+	}
+	block := seen[1]
+	if block.Path != "main.tf" || block.Range.Start.Line != 8 || block.Range.End.Line != 11 {
+		t.Errorf("Unexpected block range: %+v", block)
+	}
+	if block.FromRange.Start.Line != 9 || block.FromRange.Start.Column != 10 || block.ToRange.Start.Line != 10 {
+		t.Errorf("Unexpected from/to ranges: %+v, %+v", block.FromRange, block.ToRange)
+	}
+	if block.ToAddress == nil || block.ToAddress.Type != "aws_instance" || block.ToAddress.Name != "web" {
+		t.Errorf("Expected the parsed to address, but got %+v", block.ToAddress)
+	}
+
+	// A decider error fails the file
+	failing := decision.DeciderFunc(func(decision.Block) (decision.Result, error) {
+		return decision.Result{}, errors.New("policy service unavailable")
+	})
+	if _, err := Remove("main.tf", []byte(decideInput), Options{Deciders: []decision.Decider{failing}}); err == nil || !strings.Contains(err.Error(), "policy service unavailable") {
+		t.Errorf("Expected the decider's error, but got %v", err)
+	}
+}
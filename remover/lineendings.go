@@ -0,0 +1,67 @@
+package remover
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// utf8BOM is the byte order mark some editors write at the start of UTF-8
+// files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// TextFormat describes the byte order mark and line endings of a file, so
+// that they can be restored after the file is rewritten
+type TextFormat struct {
+	BOM  bool
+	CRLF bool
+	// Mixed is set when the file uses both CRLF and LF line endings. It is
+	// written with whichever is more common.
+	Mixed bool
+}
+
+// DetectTextFormat inspects the byte order mark and line endings of content
+func DetectTextFormat(content []byte) TextFormat {
+	f := TextFormat{BOM: bytes.HasPrefix(content, utf8BOM)}
+	crlf := bytes.Count(content, []byte("\r\n"))
+	lf := bytes.Count(content, []byte("\n")) - crlf
+	f.CRLF = crlf > lf
+	f.Mixed = crlf > 0 && lf > 0
+	return f
+}
+
+// normalize strips the byte order mark and converts CRLF line endings to LF,
+// which is what the HCL parser and formatter work with
+func (f TextFormat) Normalize(content []byte) []byte {
+	content = bytes.TrimPrefix(content, utf8BOM)
+	return bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+}
+
+// apply restores the byte order mark and line endings to normalized content
+func (f TextFormat) Apply(content []byte) []byte {
+	if f.CRLF {
+		content = bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+	}
+	if f.BOM {
+		content = append(append([]byte{}, utf8BOM...), content...)
+	}
+	return content
+}
+
+// lineEnding names the line ending the file is written with
+func (f TextFormat) lineEnding() string {
+	if f.CRLF {
+		return "CRLF"
+	}
+	return "LF"
+}
+
+// mixedLineEndingsWarning is reported for files that use both CRLF and LF
+func mixedLineEndingsWarning(filePath string, f TextFormat) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagWarning,
+		Summary:  "Mixed line endings",
+		Detail:   fmt.Sprintf("%s uses both CRLF and LF line endings. It is written with %s line endings, which are the most common in the file.", filePath, f.lineEnding()),
+	}
+}
@@ -0,0 +1,100 @@
+package remover
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "Update golden files in testdata")
+
+// TestLineEndingsGolden tests that line endings and byte order marks are
+// preserved in both whitespace modes
+func TestLineEndingsGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "line_endings", "*.input"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("No golden test inputs found: %v", err)
+	}
+
+	for _, input := range inputs {
+		content, err := os.ReadFile(input)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", input, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(input), ".input")
+		format := DetectTextFormat(content)
+
+		for _, normalize := range []bool{false, true} {
+			golden := strings.TrimSuffix(input, ".input") + ".golden"
+			if normalize {
+				golden = strings.TrimSuffix(input, ".input") + ".normalized.golden"
+			}
+
+			res, err := Remove(name, content, Options{NormalizeWhitespace: normalize})
+			if err != nil {
+				t.Fatalf("%s: Remove failed: %v", golden, err)
+			}
+			if len(res.Removed) != 1 {
+				t.Errorf("%s: expected 1 removed block, but got %d", golden, len(res.Removed))
+			}
+			result := res.Content
+
+			if *updateGolden {
+				if err := os.WriteFile(golden, result, 0644); err != nil {
+					t.Fatalf("Failed to write %s: %v", golden, err)
+				}
+				continue
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read %s (run with -update to create it): %v", golden, err)
+			}
+			if !bytes.Equal(result, expected) {
+				t.Errorf("%s: expected:\n%q\nActual:\n%q", golden, expected, result)
+			}
+
+			// Independently of the golden file, the format must be kept
+			if bytes.HasPrefix(result, utf8BOM) != format.BOM {
+				t.Errorf("%s: byte order mark was not preserved", golden)
+			}
+			crlf := bytes.Count(result, []byte("\r\n"))
+			lf := bytes.Count(result, []byte("\n"))
+			if format.CRLF && crlf != lf || !format.CRLF && crlf != 0 {
+				t.Errorf("%s: line endings were not preserved (%d CRLF, %d LF)", golden, crlf, lf-crlf)
+			}
+
+			mixedWarnings := 0
+			for _, diag := range res.Warnings {
+				if diag.Summary == "Mixed line endings" {
+					mixedWarnings++
+				}
+			}
+			if format.Mixed != (mixedWarnings == 1) {
+				t.Errorf("%s: expected a mixed line endings warning only for mixed input, got %d", golden, mixedWarnings)
+			}
+		}
+	}
+}
+
+// TestDetectTextFormat tests detecting byte order marks and line endings
+func TestDetectTextFormat(t *testing.T) {
+	testCases := []struct {
+		content  string
+		expected TextFormat
+	}{
+		{"a\nb\n", TextFormat{}},
+		{"a\r\nb\r\n", TextFormat{CRLF: true}},
+		{"\xEF\xBB\xBFa\n", TextFormat{BOM: true}},
+		{"a\r\nb\r\nc\n", TextFormat{CRLF: true, Mixed: true}},
+		{"a\r\nb\nc\n", TextFormat{Mixed: true}},
+		{"", TextFormat{}},
+	}
+	for _, tc := range testCases {
+		if got := DetectTextFormat([]byte(tc.content)); got != tc.expected {
+			t.Errorf("For %q, expected %+v, but got %+v", tc.content, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Package remover removes moved blocks from Terraform and Terragrunt
+// configuration files. It is the engine of terraform-moved-remover, which
+// adds file discovery, state and plan reconciliation and reporting on top.
+//
+// Every moved block that would be removed is first shown to the Deciders in
+// Options, so a program that imports this package can plug in its own rules
+// for which moves are safe to drop:
+//
+//	result, err := remover.Remove("main.tf", content, remover.Options{
+//		Deciders: []decision.Decider{keepProd},
+//	})
+package remover
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mkusaka/terraform-moved-remover/decision"
+)
+
+// Options controls how moved blocks are removed
+type Options struct {
+	// NormalizeWhitespace collapses runs of blank lines anywhere in a file
+	// that had moved blocks removed
+	NormalizeWhitespace bool
+	// Terragrunt also removes moved blocks from the heredoc contents of
+	// Terragrunt generate blocks
+	Terragrunt bool
+	// Deciders are asked in turn about every moved block that would be
+	// removed, and the first to keep a block decides
+	Deciders []decision.Decider
+}
+
+// MovedBlock describes a moved block found in a file
+type MovedBlock struct {
+	From      string
+	To        string
+	Range     hcl.Range
+	FromRange hcl.Range
+	ToRange   hcl.Range
+	// Reason says why the block is removed, when a decider gave a reason
+	Reason string
+	// Block is the block in the parsed file, which RemoveBlocks takes
+	Block *hclwrite.Block
+}
+
+// Text returns the source of the block, including its leading comments
+func (mb *MovedBlock) Text() string {
+	return string(mb.Block.BuildTokens(nil).Bytes())
+}
+
+// KeptBlock records a moved block that a decider kept
+type KeptBlock struct {
+	From   string
+	To     string
+	Reason string
+	// Decider is the decider that kept the block
+	Decider decision.Decider
+}
+
+// Result is the outcome of removing the moved blocks from a file
+type Result struct {
+	// Content is the formatted file, with its byte order mark and line
+	// endings kept
+	Content []byte
+	// Removed lists the removed blocks in source order, followed by those
+	// removed from Terragrunt generate blocks
+	Removed []*MovedBlock
+	Kept    []KeptBlock
+	// Warnings are problems that did not stop the file from being
+	// processed, such as mixed line endings
+	Warnings hcl.Diagnostics
+}
+
+// DiagnosticsError is returned when a file could not be parsed. The full
+// diagnostics are kept so they can be rendered with source snippets later.
+type DiagnosticsError struct {
+	Path        string
+	Diagnostics hcl.Diagnostics
+}
+
+func (e *DiagnosticsError) Error() string {
+	return fmt.Sprintf("error parsing %s: %s", e.Path, e.Diagnostics.Error())
+}
+
+// Parse parses HCL content and returns the writable file along with its
+// top-level moved blocks in source order. The content must already be
+// normalized with TextFormat.Normalize. Parse failures are returned as a
+// *DiagnosticsError.
+func Parse(filePath string, content []byte) (*hclwrite.File, []*MovedBlock, error) {
+	// Parse HCL file
+	file, diags := hclwrite.ParseConfig(content, filePath, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, nil, &DiagnosticsError{Path: filePath, Diagnostics: diags}
+	}
+
+	// hclwrite does not track source ranges, so parse the content again with
+	// hclsyntax. Both parsers see the same top-level blocks in the same order.
+	syntaxFile, diags := hclsyntax.ParseConfig(content, filePath, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, nil, &DiagnosticsError{Path: filePath, Diagnostics: diags}
+	}
+	syntaxBlocks := syntaxFile.Body.(*hclsyntax.Body).Blocks
+
+	var moved []*MovedBlock
+	for i, block := range file.Body().Blocks() {
+		if block.Type() != "moved" {
+			continue
+		}
+		mb := &MovedBlock{
+			From:  attributeText(block, "from"),
+			To:    attributeText(block, "to"),
+			Block: block,
+		}
+		if i < len(syntaxBlocks) {
+			mb.Range = syntaxBlocks[i].Range()
+			if attr, ok := syntaxBlocks[i].Body.Attributes["from"]; ok {
+				mb.FromRange = attr.Expr.Range()
+			}
+			if attr, ok := syntaxBlocks[i].Body.Attributes["to"]; ok {
+				mb.ToRange = attr.Expr.Range()
+			}
+		}
+		moved = append(moved, mb)
+	}
+
+	return file, moved, nil
+}
+
+// attributeText returns the source text of an attribute's expression, or an
+// empty string if the attribute is not set
+func attributeText(block *hclwrite.Block, name string) string {
+	attr := block.Body().GetAttribute(name)
+	if attr == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(attr.Expr().BuildTokens(nil).Bytes()))
+}
+
+// Remove removes the moved blocks that no decider keeps from the given HCL
+// content. When a decider fails, the error is returned and nothing is
+// removed.
+func Remove(filePath string, content []byte, opts Options) (*Result, error) {
+	result := &Result{}
+
+	// The byte order mark and line endings are restored on the result
+	format := DetectTextFormat(content)
+	if format.Mixed {
+		result.Warnings = append(result.Warnings, mixedLineEndingsWarning(filePath, format))
+	}
+
+	file, moved, err := Parse(filePath, format.Normalize(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var blocks []*hclwrite.Block
+	for _, mb := range moved {
+		kept, err := opts.Decide(filePath, mb)
+		if err != nil {
+			return nil, err
+		}
+		if kept != nil {
+			result.Kept = append(result.Kept, *kept)
+			continue
+		}
+		result.Removed = append(result.Removed, mb)
+		blocks = append(blocks, mb.Block)
+	}
+
+	if opts.Terragrunt {
+		if err := opts.removeGeneratedMovedBlocks(filePath, file, result); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Content = RemoveBlocks(file, blocks)
+	result.Content = Format(result.Content, opts.NormalizeWhitespace && len(result.Removed) > 0)
+	result.Content = format.Apply(result.Content)
+	return result, nil
+}
+
+// Decide asks each of opts.Deciders in turn whether a moved block must be
+// kept, and returns the block along with the reason when one of them keeps
+// it. Otherwise it returns nil and sets mb.Reason to the first reason given
+// for the removal.
+func (opts Options) Decide(filePath string, mb *MovedBlock) (*KeptBlock, error) {
+	for _, decider := range opts.Deciders {
+		result, err := decider.Decide(decisionBlock(filePath, mb))
+		if err != nil {
+			return nil, fmt.Errorf("error deciding on moved block %s -> %s in %s: %w", mb.From, mb.To, filePath, err)
+		}
+		if result.Action == decision.Keep {
+			reason := result.Reason
+			if reason == "" {
+				reason = "kept by a decider"
+			}
+			return &KeptBlock{From: mb.From, To: mb.To, Reason: reason, Decider: decider}, nil
+		}
+		if mb.Reason == "" {
+			mb.Reason = result.Reason
+		}
+	}
+	return nil, nil
+}
+
+// Format formats the source of a file after blocks have been removed from
+// it. With normalizeWhitespace, runs of blank lines anywhere in the file are
+// collapsed.
+func Format(content []byte, normalizeWhitespace bool) []byte {
+	formatted := hclwrite.Format(content)
+	if normalizeWhitespace {
+		formatted = normalizeConsecutiveNewlines(formatted)
+	}
+	return formatted
+}
+
+// normalizeConsecutiveNewlines collapses runs of blank lines in the
+// formatted content after removing moved blocks, and also removes trailing
+// empty lines
+func normalizeConsecutiveNewlines(content []byte) []byte {
+	contentStr := string(content)
+
+	re := strings.NewReplacer("\n\n\n", "\n\n", "\r\n\r\n\r\n", "\r\n\r\n")
+
+	for {
+		newContent := re.Replace(contentStr)
+		if newContent == contentStr {
+			break
+		}
+		contentStr = newContent
+	}
+
+	// First, normalize line endings to \n for processing
+	contentStr = strings.ReplaceAll(contentStr, "\r\n", "\n")
+
+	contentStr = strings.TrimRight(contentStr, "\n") + "\n"
+
+	if bytes.Contains(content, []byte("\r\n")) {
+		contentStr = strings.ReplaceAll(contentStr, "\n", "\r\n")
+	}
+
+	return []byte(contentStr)
+}
@@ -0,0 +1,123 @@
+package remover
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// removeGeneratedMovedBlocks removes moved blocks from the heredoc contents
+// of Terragrunt generate blocks and records them in result. Contents that are
+// not plain HCL are left alone with a warning.
+func (opts Options) removeGeneratedMovedBlocks(filePath string, file *hclwrite.File, result *Result) error {
+	for _, block := range file.Body().Blocks() {
+		if block.Type() != "generate" {
+			continue
+		}
+		attr := block.Body().GetAttribute("contents")
+		if attr == nil {
+			continue
+		}
+
+		tokens := attr.Expr().BuildTokens(nil)
+		start, end := -1, -1
+		for i, token := range tokens {
+			switch token.Type {
+			case hclsyntax.TokenOHeredoc:
+				if start < 0 {
+					start = i
+				}
+			case hclsyntax.TokenCHeredoc:
+				end = i
+			}
+		}
+		if start != 0 || end != len(tokens)-1 {
+			continue
+		}
+
+		name := strings.Join(block.Labels(), ".")
+		raw := hclwrite.Tokens(tokens[start+1 : end]).Bytes()
+		// Indented heredocs (<<-EOF) are parsed without their common
+		// indentation, which is restored when the contents are written back
+		indent := ""
+		if strings.HasPrefix(string(tokens[start].Bytes), "<<-") {
+			indent = heredocIndent(raw)
+			raw = reindent(raw, indent, "")
+		}
+		contents, diags := hclwrite.ParseConfig(raw, filePath, hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			result.Warnings = append(result.Warnings, &hcl.Diagnostic{
+				Severity: hcl.DiagWarning,
+				Summary:  "Generate block contents skipped",
+				Detail:   fmt.Sprintf("The contents of generate %q in %s are not plain HCL, so moved blocks in them were left unchanged.", name, filePath),
+			})
+			continue
+		}
+
+		var blocks []*hclwrite.Block
+		var moved []*MovedBlock
+		for _, inner := range contents.Body().Blocks() {
+			if inner.Type() != "moved" {
+				continue
+			}
+			mb := &MovedBlock{From: attributeText(inner, "from"), To: attributeText(inner, "to"), Block: inner}
+			kept, err := opts.Decide(filePath, mb)
+			if err != nil {
+				return err
+			}
+			if kept != nil {
+				result.Kept = append(result.Kept, *kept)
+				continue
+			}
+			moved = append(moved, mb)
+			blocks = append(blocks, inner)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+
+		newTokens := append(hclwrite.Tokens{}, tokens[:start+1]...)
+		newTokens = append(newTokens, &hclwrite.Token{
+			Type:  hclsyntax.TokenStringLit,
+			Bytes: reindent(RemoveBlocks(contents, blocks), "", indent),
+		})
+		newTokens = append(newTokens, tokens[end:]...)
+		block.Body().SetAttributeRaw("contents", newTokens)
+		result.Removed = append(result.Removed, moved...)
+	}
+	return nil
+}
+
+// heredocIndent returns the leading whitespace shared by all non-blank lines
+// of the contents of an indented heredoc
+func heredocIndent(raw []byte) string {
+	indent := ""
+	first := true
+	for _, line := range strings.Split(string(raw), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first || len(lead) < len(indent) {
+			indent = lead
+			first = false
+		}
+	}
+	return indent
+}
+
+// reindent replaces the prefix from with to on every non-blank line
+func reindent(raw []byte, from, to string) []byte {
+	lines := strings.Split(string(raw), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+			continue
+		}
+		lines[i] = to + strings.TrimPrefix(line, from)
+	}
+	return []byte(strings.Join(lines, "\n"))
+}